@@ -0,0 +1,178 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/realugbun/logger/internal/audit"
+)
+
+const defaultSegmentSize = 1000
+
+// Audit options for a tamper evident log. Each entry gets a sequence number and a hash chaining it to the previous
+// entry so edits, deletions, and reordering can be found with cmd/logverify. When the output is a file that
+// already has entries the chain continues from its last entry.
+type Audit struct {
+
+	// SigningKey signs the chain with a signature record every SegmentSize entries and when the output is closed.
+	// Each signature covers every entry before it so entries cannot be removed from the end of a signed segment.
+	SigningKey ed25519.PrivateKey
+
+	// SegmentSize is the number of entries between signatures. Defaults to 1000.
+	SegmentSize *int
+}
+
+func NewAudit() *Audit {
+	return new(Audit)
+}
+
+func (a *Audit) SetSigningKey(key ed25519.PrivateKey) *Audit {
+	a.SigningKey = key
+	return a
+}
+
+func (a *Audit) GetSigningKey() ed25519.PrivateKey {
+	return a.SigningKey
+}
+
+func (a *Audit) SetSegmentSize(n int) *Audit {
+	a.SegmentSize = &n
+	return a
+}
+
+func (a *Audit) GetSegmentSize() int {
+	if a.SegmentSize == nil {
+		return defaultSegmentSize
+	}
+	return *a.SegmentSize
+}
+
+// validate checks the audit options
+func (a *Audit) validate() error {
+
+	if a.SigningKey != nil && len(a.SigningKey) != ed25519.PrivateKeySize {
+		return &OptionError{Option: "Audit.SigningKey", Reason: fmt.Sprintf("must be %d bytes", ed25519.PrivateKeySize)}
+	}
+
+	if a.GetSegmentSize() < 1 {
+		return &OptionError{Option: "Audit.SegmentSize", Reason: "must be positive"}
+	}
+
+	return nil
+}
+
+// auditWriter adds the sequence number and hash to each entry as it is written
+type auditWriter struct {
+	mu sync.Mutex
+
+	w io.Writer
+
+	// file is closed with the writer. It is nil when writing to standard error.
+	file io.Closer
+
+	chain       audit.Chain
+	key         ed25519.PrivateKey
+	segmentSize int
+
+	// unsigned is the number of entries since the last signature
+	unsigned int
+}
+
+// newAuditWriter wraps the output. If file has entries the chain continues from the last one.
+func newAuditWriter(w io.Writer, file *os.File, a *Audit) (*auditWriter, error) {
+
+	aw := &auditWriter{
+		w:           w,
+		key:         a.GetSigningKey(),
+		segmentSize: a.GetSegmentSize(),
+	}
+
+	if file == nil {
+		return aw, nil
+	}
+	aw.file = file
+
+	info, err := file.Stat()
+	if err != nil {
+		return aw, err
+	}
+
+	if aw.chain, err = audit.Resume(file, info.Size()); err != nil {
+		return aw, err
+	}
+
+	// A line cut off by a crash is ended so the next entry starts on its own line
+	if info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, err = w.Write([]byte("\n"))
+			return aw, err
+		}
+	}
+
+	return aw, nil
+}
+
+// Write adds the audit fields to each line. logrus writes one entry per call.
+// The chain only moves on once the lines are written so a failed write does not leave a gap in it.
+func (w *auditWriter) Write(p []byte) (int, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	chain, unsigned := w.chain, w.unsigned
+
+	var out bytes.Buffer
+	for _, line := range bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n")) {
+
+		out.Write(chain.Append(line))
+		out.WriteByte('\n')
+
+		unsigned++
+		if w.key != nil && unsigned >= w.segmentSize {
+			w.sign(&out, chain)
+			unsigned = 0
+		}
+	}
+
+	if _, err := w.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+
+	w.chain, w.unsigned = chain, unsigned
+	return len(p), nil
+}
+
+// sign writes a signature record for the last entry of the chain
+func (w *auditWriter) sign(out *bytes.Buffer, chain audit.Chain) {
+	out.Write(chain.Sign(w.key))
+	out.WriteByte('\n')
+}
+
+// Close signs the entries since the last signature then closes the file
+func (w *auditWriter) Close() error {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	if w.key != nil && w.unsigned > 0 {
+		var out bytes.Buffer
+		w.sign(&out, w.chain)
+		if _, err = w.w.Write(out.Bytes()); err == nil {
+			w.unsigned = 0
+		}
+	}
+
+	if w.file != nil {
+		if cerr := w.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,31 @@
+//go:build go1.18
+// +build go1.18
+
+package logger
+
+import "runtime/debug"
+
+// buildInfoFields gets the Go version, main module version, and VCS revision the binary was built with
+func buildInfoFields() Fields {
+
+	fields := Fields{}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fields
+	}
+
+	fields[FieldKeyGoVersion] = bi.GoVersion
+
+	if bi.Main.Version != "" {
+		fields[FieldKeyModuleVersion] = bi.Main.Version
+	}
+
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			fields[FieldKeyVCSRevision] = s.Value
+		}
+	}
+
+	return fields
+}
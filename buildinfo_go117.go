@@ -0,0 +1,27 @@
+//go:build !go1.18
+// +build !go1.18
+
+package logger
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// buildInfoFields gets the Go version and main module version the binary was built with.
+// Go 1.17 does not record the VCS revision in the build info.
+func buildInfoFields() Fields {
+
+	fields := Fields{FieldKeyGoVersion: runtime.Version()}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fields
+	}
+
+	if bi.Main.Version != "" {
+		fields[FieldKeyModuleVersion] = bi.Main.Version
+	}
+
+	return fields
+}
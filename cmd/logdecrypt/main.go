@@ -0,0 +1,127 @@
+// Command logdecrypt writes the plaintext of log files encrypted with the EncryptionKey option.
+//
+// Usage:
+//
+//	logdecrypt [flags] [file ...]
+//
+// The key is read from a file or an environment variable as hex or base64. Both flags may be repeated
+// to read files written before and after a key was rotated. Standard input is read when no files are given.
+// The output can be piped to logview or logstats.
+//
+//	logdecrypt -key-file /run/secrets/log-key app.log | logview -level error
+//	logdecrypt -genkey > log-key
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/realugbun/logger/internal/logfile"
+	"github.com/realugbun/logger/logcrypt"
+)
+
+// keyFlags collects the keys from the repeated -key-file and -key-env flags
+type keyFlags struct {
+	keys   [][]byte
+	source func(string) logcrypt.KeySource
+	names  []string
+}
+
+func (f *keyFlags) String() string {
+	return strings.Join(f.names, ",")
+}
+
+func (f *keyFlags) Set(s string) error {
+	key, err := f.source(s)()
+	if err != nil {
+		return err
+	}
+	f.keys = append(f.keys, key)
+	f.names = append(f.names, s)
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the flags then decrypts each file to stdout. It returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+
+	fs := flag.NewFlagSet("logdecrypt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: logdecrypt [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+
+	var (
+		files  = keyFlags{source: logcrypt.KeyFromFile}
+		envs   = keyFlags{source: logcrypt.KeyFromEnv}
+		genkey = fs.Bool("genkey", false, "print a new random AES-256 key as hex and exit")
+	)
+	fs.Var(&files, "key-file", "file with the key as hex or base64. May be repeated.")
+	fs.Var(&envs, "key-env", "environment variable with the key as hex or base64. May be repeated.")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *genkey {
+		key, err := logcrypt.GenerateKey()
+		if err != nil {
+			fmt.Fprintln(stderr, "logdecrypt:", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, hex.EncodeToString(key))
+		return 0
+	}
+
+	keys := append(files.keys, envs.keys...)
+	if len(keys) == 0 {
+		fmt.Fprintln(stderr, "logdecrypt: -key-file or -key-env is required")
+		return 2
+	}
+
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{logfile.Stdin}
+	}
+
+	code := 0
+	for _, name := range names {
+		if err := decrypt(name, keys, stdout); err != nil {
+			fmt.Fprintf(stderr, "logdecrypt: %s: %v\n", name, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// decrypt copies the plaintext of a file to w. Entries before a damaged or cut off frame are still written.
+func decrypt(name string, keys [][]byte, w io.Writer) error {
+
+	rc, err := logfile.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	r, err := logcrypt.NewReader(rc, keys...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		if errors.Is(err, logcrypt.ErrTruncated) {
+			return errors.New("the last entry was cut off")
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/realugbun/logger/logcrypt"
+)
+
+func Test_run(t *testing.T) {
+
+	dir := t.TempDir()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	keyFile := filepath.Join(dir, "log-key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(oldKey)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("Test_run_key", hex.EncodeToString(newKey))
+	defer os.Unsetenv("Test_run_key")
+
+	// The file was appended to after the key was rotated
+	var log bytes.Buffer
+	for i, key := range [][]byte{oldKey, newKey} {
+		w, _ := logcrypt.NewWriter(&log, key)
+		w.Write([]byte(`{"msg":"entry ` + string(rune('1'+i)) + `"}` + "\n"))
+	}
+	name := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(name, log.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"-key-file", keyFile, "-key-env", "Test_run_key", name}, &stdout, &stderr), stderr.String())
+	assert.Equal(t, `{"msg":"entry 1"}`+"\n"+`{"msg":"entry 2"}`+"\n", stdout.String())
+
+	// Without the new key the entries before it are written
+	stdout.Reset()
+	assert.Equal(t, 1, run([]string{"-key-file", keyFile, name}, &stdout, &stderr))
+	assert.Equal(t, `{"msg":"entry 1"}`+"\n", stdout.String())
+	assert.Contains(t, stderr.String(), "encrypted with key "+logcrypt.KeyID(newKey))
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"-genkey"}, &stdout, &stderr))
+	_, err := logcrypt.ParseKey(stdout.String())
+	assert.NoError(t, err)
+}
+
+func Test_runErrors(t *testing.T) {
+
+	for _, args := range [][]string{
+		{"app.log"},
+		{"-key-file", filepath.Join("testdata", "missing-key")},
+		{"-key-env", "Test_runErrors_missing"},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, run(args, &stdout, &stderr), args)
+		assert.NotEmpty(t, stderr.String(), args)
+	}
+}
@@ -0,0 +1,118 @@
+// Command logstats summarizes the JSON lines written by the logger.
+//
+// Usage:
+//
+//	logstats [flags] [file ...]
+//
+// Entries are counted by level, by caller, by message with numbers and ids replaced by placeholders,
+// and by error fingerprint. Each count is broken down over time buckets to show when it happened.
+// Files compressed with gzip are decompressed and standard input is read when no files are given.
+//
+//	logstats -top 20 app.log app.log.1.gz
+//	logstats -bucket 5m -format json app.log
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+// maxBuckets stops a small -bucket over a long time range making huge reports
+const maxBuckets = 1000
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the flags then reads each file and writes the report. It returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+
+	fs := flag.NewFlagSet("logstats", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: logstats [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+
+	var (
+		top    = fs.Int("top", 10, "number of callers, messages, and errors to report")
+		bucket = fs.Duration("bucket", 0, "size of the time buckets ie 5m or 1h. Chosen from the time range when not set.")
+		format = fs.String("format", "text", "report format: text or json")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "logstats: invalid format %q, must be text or json\n", *format)
+		return 2
+	}
+	if *top < 1 || *bucket < 0 {
+		fmt.Fprintln(stderr, "logstats: -top and -bucket must be positive")
+		return 2
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{logfile.Stdin}
+	}
+
+	s := newStats(*bucket)
+
+	code := 0
+	for _, name := range files {
+		if err := read(name, s); err != nil {
+			fmt.Fprintf(stderr, "logstats: %s: %v\n", name, err)
+			code = 1
+		}
+	}
+
+	if !s.start.IsZero() && s.end.Sub(s.start)/s.bucketSize(*bucket) >= maxBuckets {
+		fmt.Fprintf(stderr, "logstats: more than %d buckets, use a larger -bucket\n", maxBuckets)
+		return 2
+	}
+
+	r := newReport(s, *top, *bucket)
+
+	var err error
+	if *format == "json" {
+		err = r.writeJSON(stdout)
+	} else {
+		err = r.writeText(stdout, *top)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "logstats:", err)
+		return 1
+	}
+
+	return code
+}
+
+// read adds the entries of a file to the stats. Lines which are not JSON are skipped.
+func read(name string, s *stats) error {
+
+	rc, err := logfile.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	r := logfile.NewReader(rc)
+	for {
+		entry, _, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			s.add(entry)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testLog = `{"level":"info","msg":"upload 1 of 3 started","time":"2022-03-04T15:01:00Z","file":"/app/uploads/handler.go","line":42,"func":"uploads.Handle"}
+{"level":"info","msg":"upload 2 of 3 started","time":"2022-03-04T15:20:00Z","file":"/app/uploads/handler.go","line":42,"func":"uploads.Handle"}
+{"level":"error","msg":"upload failed","time":"2022-03-04T15:40:00Z","file":"/app/uploads/handler.go","line":50,"func":"uploads.Handle","error":"open /tmp/upload-3.csv: no space left, 512 bytes free"}
+not json
+{"level":"error","msg":"upload failed","time":"2022-03-04T16:10:00Z","file":"/app/uploads/handler.go","line":50,"func":"uploads.Handle","error":"open /tmp/upload-9.csv: no space left, 12 bytes free"}
+{"level":"error","msg":"user b7ad6b71 not found","time":"2022-03-04T16:59:00Z","file":"/app/users/get.go","line":8,"func":"users.Get"}
+`
+
+func Test_run(t *testing.T) {
+
+	name := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(name, []byte(testLog), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if !assert.Equal(t, 0, run([]string{"-format", "json", "-top", "2", "-bucket", "15m", name}, &stdout, &stderr), stderr.String()) {
+		return
+	}
+
+	var r struct {
+		Total    int
+		Bucket   string
+		Buckets  []string
+		Levels   []group
+		Callers  []group
+		Messages []group
+		Errors   []group
+	}
+	if !assert.NoError(t, json.Unmarshal(stdout.Bytes(), &r)) {
+		return
+	}
+
+	assert.Equal(t, 5, r.Total)
+	assert.Equal(t, "15m0s", r.Bucket)
+	assert.Len(t, r.Buckets, 8)
+
+	assert.Equal(t, []group{
+		{Key: "error", Count: 3, Buckets: []int{0, 0, 1, 0, 1, 0, 0, 1}},
+		{Key: "info", Count: 2, Buckets: []int{1, 1, 0, 0, 0, 0, 0, 0}},
+	}, r.Levels)
+
+	assert.Equal(t, "uploads/handler.go:42 uploads.Handle", r.Callers[0].Key)
+	assert.Len(t, r.Callers, 2)
+
+	assert.Equal(t, "upload <n> of <n> started", r.Messages[0].Key)
+	assert.Equal(t, "upload 1 of 3 started", r.Messages[0].Example)
+
+	// Errors which only differ by numbers are grouped
+	if assert.Len(t, r.Errors, 2) {
+		assert.Equal(t, 2, r.Errors[0].Count)
+		assert.Equal(t, "uploads/handler.go:50 uploads.Handle", r.Errors[0].Caller)
+		assert.Equal(t, "open /tmp/upload-3.csv: no space left, 512 bytes free", r.Errors[0].Example)
+		assert.Equal(t, "user b7ad6b71 not found", r.Errors[1].Example)
+	}
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{name}, &stdout, &stderr))
+
+	text := stdout.String()
+	assert.True(t, strings.HasPrefix(text, "5 entries from 2022-03-04T15:01:00Z to 2022-03-04T16:59:00Z in 5m0s buckets\n"), text)
+	for _, s := range []string{"Levels", "Top 10 callers", "Top 10 messages", "Top 10 errors", "users/get.go:8 users.Get", "60.0%"} {
+		assert.Contains(t, text, s)
+	}
+}
+
+func Test_runErrors(t *testing.T) {
+
+	for _, args := range [][]string{
+		{"-format", "xml"},
+		{"-top", "0"},
+		{filepath.Join("testdata", "missing.log")},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.NotEqual(t, 0, run(args, &stdout, &stderr), args)
+		assert.NotEmpty(t, stderr.String(), args)
+	}
+}
+
+func Test_template(t *testing.T) {
+
+	for _, tc := range []struct {
+		in  string
+		exp string
+	}{
+		{in: "upload 12 of 30 took 1.5s", exp: "upload <n> of <n> took <n>"},
+		{in: `file "a b.csv" not found`, exp: "file <str> not found"},
+		{in: "user b7ad6b71 and 4bf92f35-77b3-4da6-a3ce-929d0e0e4736", exp: "user <hex> and <uuid>"},
+		{in: "dial tcp 10.0.0.1:5432: connection refused", exp: "dial tcp <ip>: connection refused"},
+		{in: "started at 2022-03-04T15:04:05Z", exp: "started at <time>"},
+		{in: "cache miss for face and dead", exp: "cache miss for face and dead"},
+	} {
+		assert.Equal(t, tc.exp, template(tc.in), tc.in)
+	}
+}
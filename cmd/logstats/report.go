@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sparks draw the counts per bucket from lowest to highest
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// report is the summary written as text or JSON
+type report struct {
+	Total    int         `json:"total"`
+	Start    *time.Time  `json:"start,omitempty"`
+	End      *time.Time  `json:"end,omitempty"`
+	Bucket   string      `json:"bucket,omitempty"`
+	Buckets  []time.Time `json:"buckets,omitempty"`
+	Levels   []*group    `json:"levels"`
+	Callers  []*group    `json:"callers"`
+	Messages []*group    `json:"messages"`
+	Errors   []*group    `json:"errors"`
+}
+
+func newReport(s *stats, top int, bucket time.Duration) *report {
+
+	bucket = s.bucketSize(bucket)
+
+	r := &report{
+		Total:    s.total,
+		Levels:   s.top(s.levels, 0, bucket),
+		Callers:  s.top(s.callers, top, bucket),
+		Messages: s.top(s.messages, top, bucket),
+		Errors:   s.top(s.errors, top, bucket),
+	}
+
+	if !s.start.IsZero() {
+		r.Start, r.End = &s.start, &s.end
+		r.Bucket = bucket.String()
+		r.Buckets = s.buckets(bucket)
+	}
+
+	return r
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+func (r *report) writeText(w io.Writer, top int) error {
+
+	fmt.Fprintf(w, "%d entries", r.Total)
+	if r.Start != nil {
+		fmt.Fprintf(w, " from %s to %s in %s buckets", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Bucket)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	section(tw, "Levels", []string{"LEVEL"}, r.Total, r.Levels, func(g *group) []string {
+		return []string{g.Key}
+	})
+	section(tw, fmt.Sprintf("Top %d callers", top), []string{"CALLER"}, r.Total, r.Callers, func(g *group) []string {
+		return []string{g.Key}
+	})
+	section(tw, fmt.Sprintf("Top %d messages", top), []string{"MESSAGE"}, r.Total, r.Messages, func(g *group) []string {
+		return []string{g.Key}
+	})
+	section(tw, fmt.Sprintf("Top %d errors", top), []string{"FINGERPRINT", "CALLER", "EXAMPLE"}, r.Total, r.Errors, func(g *group) []string {
+		return []string{g.Key, g.Caller, truncate(g.Example, 120)}
+	})
+
+	return tw.Flush()
+}
+
+// section writes a table of groups with their count, share of all entries, and counts over time
+func section(w io.Writer, title string, columns []string, total int, groups []*group, row func(*group) []string) {
+
+	fmt.Fprintf(w, "\n%s\n", title)
+	if len(groups) == 0 {
+		fmt.Fprintln(w, "  none")
+		return
+	}
+
+	fmt.Fprintf(w, "  COUNT\t%%\tTREND\t%s\n", strings.Join(columns, "\t"))
+	for _, g := range groups {
+		share := 100 * float64(g.Count) / float64(total)
+		fmt.Fprintf(w, "  %d\t%.1f%%\t%s\t%s\n", g.Count, share, sparkline(g.Buckets), strings.Join(row(g), "\t"))
+	}
+}
+
+// sparkline draws counts relative to the largest
+func sparkline(counts []int) string {
+
+	max := 0
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+
+	var sb strings.Builder
+	for _, c := range counts {
+		switch {
+		case c == 0:
+			sb.WriteRune(' ')
+		default:
+			sb.WriteRune(sparks[(c*len(sparks)-1)/max])
+		}
+	}
+	return sb.String()
+}
+
+// truncate shortens s to n runes on one line
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+// autoBuckets are the bucket sizes chosen from when -bucket is not set
+var autoBuckets = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// maxAutoBuckets is the most buckets an automatically chosen size gives
+const maxAutoBuckets = 24
+
+// unknown is the key of entries without a caller or level
+const unknown = "(unknown)"
+
+// group counts the entries sharing a key such as a caller or message template
+type group struct {
+	Key     string `json:"key"`
+	Count   int    `json:"count"`
+	Buckets []int  `json:"buckets,omitempty"`
+
+	// Example is the first message or error in the group before it was templated
+	Example string `json:"example,omitempty"`
+
+	// Caller is where errors with the fingerprint were logged
+	Caller string `json:"caller,omitempty"`
+
+	// times counts the entries by their time truncated to stats.base
+	times map[int64]int
+}
+
+// stats aggregates entries by level, caller, message template, and error fingerprint
+type stats struct {
+	// base is the precision times are kept at. Buckets are multiples of it.
+	base time.Duration
+
+	total      int
+	start, end time.Time
+
+	levels   map[string]*group
+	callers  map[string]*group
+	messages map[string]*group
+	errors   map[string]*group
+}
+
+func newStats(bucket time.Duration) *stats {
+
+	// The automatic sizes are all multiples of a minute
+	base := bucket
+	if base == 0 {
+		base = time.Minute
+	}
+
+	return &stats{
+		base:     base,
+		levels:   make(map[string]*group),
+		callers:  make(map[string]*group),
+		messages: make(map[string]*group),
+		errors:   make(map[string]*group),
+	}
+}
+
+// add counts an entry in each of the groupings
+func (s *stats) add(e logfile.Entry) {
+
+	s.total++
+
+	t, ok := e.Time()
+	if ok {
+		if s.start.IsZero() || t.Before(s.start) {
+			s.start = t
+		}
+		if t.After(s.end) {
+			s.end = t
+		}
+	}
+
+	level := e.Level()
+	if level == "" {
+		level = unknown
+	}
+	s.count(s.levels, level, t, ok)
+
+	caller := e.ShortCaller()
+	if caller == "" {
+		caller = unknown
+	}
+	s.count(s.callers, caller, t, ok)
+
+	msg := e.Msg()
+	if g := s.count(s.messages, template(msg), t, ok); g.Example == "" {
+		g.Example = msg
+	}
+
+	if err := errorText(e, level); err != "" {
+		g := s.count(s.errors, fingerprint(err, caller), t, ok)
+		if g.Example == "" {
+			g.Example = err
+			g.Caller = caller
+		}
+	}
+}
+
+func (s *stats) count(groups map[string]*group, key string, t time.Time, hasTime bool) *group {
+
+	g, ok := groups[key]
+	if !ok {
+		g = &group{Key: key, times: make(map[int64]int)}
+		groups[key] = g
+	}
+
+	g.Count++
+	if hasTime {
+		g.times[t.Truncate(s.base).UnixNano()]++
+	}
+
+	return g
+}
+
+// errorText gets the error of an entry from its error field or the message of entries at level Error or above
+func errorText(e logfile.Entry, level string) string {
+
+	for _, k := range []string{"error", "err"} {
+		if err := e.String(k); err != "" {
+			return err
+		}
+	}
+
+	switch level {
+	case "error", "fatal", "panic":
+		return e.Msg()
+	}
+	return ""
+}
+
+// bucketSize gets the bucket size to report with. An unset size is chosen to give at most maxAutoBuckets.
+func (s *stats) bucketSize(bucket time.Duration) time.Duration {
+
+	if bucket > 0 {
+		return bucket
+	}
+
+	span := s.end.Sub(s.start)
+	for _, b := range autoBuckets {
+		if span/b < maxAutoBuckets {
+			return b
+		}
+	}
+	return autoBuckets[len(autoBuckets)-1]
+}
+
+// top sorts the groups by count and returns the first n with their counts per bucket
+func (s *stats) top(groups map[string]*group, n int, bucket time.Duration) []*group {
+
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+
+	first := s.start.Truncate(bucket)
+	buckets := s.buckets(bucket)
+	for _, g := range sorted {
+		if s.start.IsZero() {
+			continue
+		}
+		g.Buckets = make([]int, len(buckets))
+		for t, c := range g.times {
+			g.Buckets[time.Unix(0, t).Sub(first)/bucket] += c
+		}
+	}
+
+	return sorted
+}
+
+// buckets gets the start time of each bucket from the first to the last entry
+func (s *stats) buckets(bucket time.Duration) []time.Time {
+
+	if s.start.IsZero() {
+		return nil
+	}
+
+	var buckets []time.Time
+	for t := s.start.Truncate(bucket); !t.After(s.end); t = t.Add(bucket) {
+		buckets = append(buckets, t)
+	}
+	return buckets
+}
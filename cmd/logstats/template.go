@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+)
+
+// placeholders replace the variable parts of messages so messages from the same call group together.
+// They are applied in order so more specific patterns come first.
+var placeholders = []struct {
+	pattern *regexp.Regexp
+	name    string
+}{
+	{regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`), "<str>"},
+	{regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]*\d[0-9a-f]*[a-f][0-9a-f]*\b|\b[0-9a-f]*[a-f][0-9a-f]*\d[0-9a-f]*\b`), "<hex>"},
+	{regexp.MustCompile(`\b\d+(?:\.\d+)?(?:ns|us|ms|s|m|h)?\b`), "<n>"},
+}
+
+// template replaces quoted strings, times, ids, addresses, and numbers in a message with placeholders
+func template(msg string) string {
+	for _, p := range placeholders {
+		msg = p.pattern.ReplaceAllString(msg, p.name)
+	}
+	return msg
+}
+
+// fingerprint identifies an error by the template of its message and where it was logged
+func fingerprint(err, caller string) string {
+	sum := sha1.Sum([]byte(template(err) + "\x00" + caller))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,179 @@
+// Command logverify checks an audit log written with the Audit option has not been changed.
+//
+// Usage:
+//
+//	logverify [flags] file ...
+//
+// Each entry's hash is checked against the previous entry so edits, deletions, and reordering are reported
+// with the line they were found on. Rotated files are given oldest first and are checked as one chain.
+// When a public key is given the signature records are verified too and entries after the last valid signature
+// are a problem, as the signatures after an edit could have been removed. Use -require-signed=false to check a
+// log which is still being written. It exits with 1 when a problem is found.
+//
+//	logverify -key audit.pub admin.log.1.gz admin.log
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/realugbun/logger/internal/audit"
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the flags then verifies the files in order. It returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+
+	fs := flag.NewFlagSet("logverify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: logverify [flags] file ...")
+		fs.PrintDefaults()
+	}
+
+	var (
+		keyFile       = fs.String("key", "", "file with the ed25519 public key as hex, base64, or PEM. Signatures are not checked when not set.")
+		requireSigned = fs.Bool("require-signed", true, "report entries after the last valid signature as a problem. Only used with -key.")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	requireSignedSet := false
+	fs.Visit(func(f *flag.Flag) {
+		requireSignedSet = requireSignedSet || f.Name == "require-signed"
+	})
+	if requireSignedSet && *requireSigned && *keyFile == "" {
+		fmt.Fprintln(stderr, "logverify: -require-signed needs -key")
+		return 2
+	}
+
+	var key ed25519.PublicKey
+	if *keyFile != "" {
+		var err error
+		if key, err = readKey(*keyFile); err != nil {
+			fmt.Fprintf(stderr, "logverify: %s: %v\n", *keyFile, err)
+			return 2
+		}
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{logfile.Stdin}
+	}
+
+	v := audit.NewVerifier(key)
+	for _, name := range files {
+		if err := read(name, v); err != nil {
+			fmt.Fprintf(stderr, "logverify: %s: %v\n", name, err)
+			return 2
+		}
+	}
+
+	r := v.Result()
+	for _, p := range r.Problems {
+		fmt.Fprintln(stdout, p)
+	}
+
+	problems := len(r.Problems)
+	if key != nil && *requireSigned && r.Unsigned > 0 {
+		if r.LastSigned == 0 {
+			fmt.Fprintf(stdout, "%s not signed\n", plural(r.Unsigned, "entry"))
+		} else {
+			fmt.Fprintf(stdout, "%s after seq %d not signed\n", plural(r.Unsigned, "entry"), r.LastSigned)
+		}
+		problems++
+	}
+
+	fmt.Fprintf(stdout, "%s, %s", plural(r.Entries, "entry"), plural(r.Signatures, "signature"))
+	if key != nil {
+		fmt.Fprintf(stdout, ", signed to seq %d, %d unsigned", r.LastSigned, r.Unsigned)
+	}
+	fmt.Fprintf(stdout, ", %s\n", plural(problems, "problem"))
+
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
+
+// read passes each line of a file to the verifier
+func read(name string, v *audit.Verifier) error {
+
+	rc, err := logfile.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	v.StartFile(name)
+
+	r := logfile.NewReader(rc)
+	for {
+		_, line, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v.Line(line)
+	}
+}
+
+// readKey reads a public key written as hex, base64, or a PEM PUBLIC KEY block
+func readKey(name string) (ed25519.PublicKey, error) {
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(b); block != nil {
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if pub, ok := k.(ed25519.PublicKey); ok {
+			return pub, nil
+		}
+		return nil, errors.New("not an ed25519 public key")
+	}
+
+	s := string(bytes.TrimSpace(b))
+	if k, err := hex.DecodeString(s); err == nil && len(k) == ed25519.PublicKeySize {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == ed25519.PublicKeySize {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("not a %d byte ed25519 public key", ed25519.PublicKeySize)
+}
+
+// plural formats a count with the noun ie 1 entry or 2 entries
+func plural(n int, noun string) string {
+	switch {
+	case n == 1:
+		return "1 " + noun
+	case strings.HasSuffix(noun, "y"):
+		return strconv.Itoa(n) + " " + strings.TrimSuffix(noun, "y") + "ies"
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
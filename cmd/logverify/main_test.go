@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/realugbun/logger/internal/audit"
+)
+
+func Test_run(t *testing.T) {
+
+	dir := t.TempDir()
+	pub, key, _ := ed25519.GenerateKey(nil)
+
+	keyFile := filepath.Join(dir, "audit.pub")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(pub)+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two rotated files with a signature at the end of the first
+	var (
+		c    audit.Chain
+		logs [2]bytes.Buffer
+	)
+	for i, msg := range []string{"user a deleted", "user b deleted", "user c deleted", "user d deleted"} {
+		logs[i/2].Write(c.Append([]byte(`{"level":"info","msg":"` + msg + `"}`)))
+		logs[i/2].WriteByte('\n')
+		if i == 1 {
+			logs[0].Write(c.Sign(key))
+			logs[0].WriteByte('\n')
+		}
+	}
+
+	old, current := filepath.Join(dir, "admin.log.1"), filepath.Join(dir, "admin.log")
+	for i, name := range []string{old, current} {
+		if err := os.WriteFile(name, logs[i].Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		code    int
+		problem string
+		summary string
+	}{
+		{
+			name:    "unchanged",
+			args:    []string{"-key", keyFile, "-require-signed=false", old, current},
+			summary: "4 entries, 1 signature, signed to seq 2, 2 unsigned, 0 problems",
+		},
+		{
+			name:    "signed",
+			args:    []string{"-key", keyFile, old},
+			summary: "2 entries, 1 signature, signed to seq 2, 0 unsigned, 0 problems",
+		},
+		{
+			name:    "without key",
+			args:    []string{old, current},
+			summary: "4 entries, 1 signature, 0 problems",
+		},
+		{
+			name:    "unsigned by default with key",
+			args:    []string{"-key", keyFile, old, current},
+			code:    1,
+			problem: "2 entries after seq 2 not signed",
+			summary: "4 entries, 1 signature, signed to seq 2, 2 unsigned, 1 problem",
+		},
+		{
+			name:    "rotated file missing",
+			args:    []string{"-key", keyFile, current},
+			code:    1,
+			problem: current + ":1: seq 3: chain starts at seq 3",
+		},
+		{
+			name:    "files out of order",
+			args:    []string{current, old},
+			code:    1,
+			problem: old + ":1: seq 1: seq after 4, the entry was moved or repeated",
+		},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, tc.code, run(tc.args, &stdout, &stderr), tc.name, stderr.String())
+		if tc.problem != "" {
+			assert.Contains(t, stdout.String(), tc.problem, tc.name)
+		}
+		if tc.summary != "" {
+			assert.True(t, strings.HasSuffix(stdout.String(), tc.summary+"\n"), tc.name, stdout.String())
+		}
+	}
+
+	// An edited entry is reported
+	edited := filepath.Join(dir, "edited.log")
+	if err := os.WriteFile(edited, bytes.Replace(logs[0].Bytes(), []byte("user b"), []byte("user x"), 1), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{edited}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), edited+":2: seq 2: hash does not match, the entry was edited")
+
+	// An entry edited with every later hash recomputed and the signatures after it removed passes the chain
+	// check but is not signed
+	var (
+		forged audit.Chain
+		buf    bytes.Buffer
+	)
+	for _, msg := range []string{"user a deleted", "user x deleted"} {
+		buf.Write(forged.Append([]byte(`{"level":"info","msg":"` + msg + `"}`)))
+		buf.WriteByte('\n')
+	}
+	stripped := filepath.Join(dir, "stripped.log")
+	if err := os.WriteFile(stripped, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{stripped}, &stdout, &stderr))
+	stdout.Reset()
+	assert.Equal(t, 1, run([]string{"-key", keyFile, stripped}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "2 entries not signed")
+}
+
+func Test_runErrors(t *testing.T) {
+
+	for _, args := range [][]string{
+		{"-require-signed", "admin.log"},
+		{"-key", filepath.Join("testdata", "missing.pub"), "admin.log"},
+		{filepath.Join("testdata", "missing.log")},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, run(args, &stdout, &stderr), args)
+		assert.NotEmpty(t, stderr.String(), args)
+	}
+}
+
+func Test_readKey(t *testing.T) {
+
+	dir := t.TempDir()
+	pub, _, _ := ed25519.GenerateKey(nil)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		data   string
+		expErr bool
+	}{
+		{name: "hex", data: hex.EncodeToString(pub) + "\n"},
+		{name: "base64", data: base64.StdEncoding.EncodeToString(pub)},
+		{name: "pem", data: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+		{name: "short", data: hex.EncodeToString(pub[:16]), expErr: true},
+	} {
+		name := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(name, []byte(tc.data), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := readKey(name)
+		if tc.expErr {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, pub, key, tc.name)
+		}
+	}
+}
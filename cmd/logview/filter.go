@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/realugbun/logger/internal/logfile"
+	"github.com/sirupsen/logrus"
+)
+
+// fieldFlags collects the repeated -field key=value flags
+type fieldFlags map[string]string
+
+func (f *fieldFlags) String() string {
+	pairs := make([]string, 0, len(*f))
+	for k, v := range *f {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *fieldFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("%q must be key=value", s)
+	}
+	if *f == nil {
+		*f = make(fieldFlags)
+	}
+	(*f)[kv[0]] = kv[1]
+	return nil
+}
+
+// filter decides which entries are shown
+type filter struct {
+	level  logrus.Level
+	since  time.Time
+	until  time.Time
+	msg    *regexp.Regexp
+	fields fieldFlags
+}
+
+func newFilter(level, since, until, msg string, fields fieldFlags) (*filter, error) {
+
+	f := &filter{fields: fields}
+	now := time.Now()
+
+	var err error
+	if f.level, err = logrus.ParseLevel(level); err != nil {
+		return nil, err
+	}
+	if f.since, err = parseTime(since, now); err != nil {
+		return nil, fmt.Errorf("invalid -since, %w", err)
+	}
+	if f.until, err = parseTime(until, now); err != nil {
+		return nil, fmt.Errorf("invalid -until, %w", err)
+	}
+	if msg != "" {
+		if f.msg, err = regexp.Compile(msg); err != nil {
+			return nil, fmt.Errorf("invalid -grep, %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// match checks an entry against every filter
+func (f *filter) match(e logfile.Entry) bool {
+
+	if f.level < logrus.TraceLevel {
+		level, err := logrus.ParseLevel(e.Level())
+		if err != nil || level > f.level {
+			return false
+		}
+	}
+
+	if !f.since.IsZero() || !f.until.IsZero() {
+		t, ok := e.Time()
+		if !ok || (!f.since.IsZero() && t.Before(f.since)) || (!f.until.IsZero() && !t.Before(f.until)) {
+			return false
+		}
+	}
+
+	if f.msg != nil && !f.msg.MatchString(e.Msg()) {
+		return false
+	}
+
+	for k, v := range f.fields {
+		if _, ok := e.Field(k); !ok || e.String(k) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchRaw checks if lines which are not JSON are shown. They are hidden by any filter as they have no fields.
+func (f *filter) matchRaw() bool {
+	return f.level == logrus.TraceLevel && f.since.IsZero() && f.until.IsZero() && f.msg == nil && len(f.fields) == 0
+}
+
+// parseTime reads an RFC3339 time, a date, or a duration before now. An empty string is the zero time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q is not a time, date, or duration", s)
+}
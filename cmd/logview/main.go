@@ -0,0 +1,153 @@
+// Command logview pretty prints the JSON lines written by the logger.
+//
+// Usage:
+//
+//	logview [flags] [file ...]
+//
+// Files compressed with gzip are decompressed and standard input is read when no files are given.
+// Each entry is printed on one line with its time, level, message, custom fields, and caller.
+//
+//	logview -level warn -since 1h -field request_id=b7ad6b71 app.log app.log.1.gz
+//	logview -f -grep 'upload (failed|retried)' -trace app.log
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the flags then prints the entries from each file and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+
+	fs := flag.NewFlagSet("logview", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: logview [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+
+	var (
+		level   = fs.String("level", "trace", "only show entries at this level or more severe")
+		since   = fs.String("since", "", "only show entries at or after this time, an RFC3339 time, a date, or a duration ago ie 1h")
+		until   = fs.String("until", "", "only show entries before this time, in the same formats as -since")
+		grep    = fs.String("grep", "", "only show entries whose message matches this regular expression")
+		follow  = fs.Bool("f", false, "keep reading the last file as it grows")
+		trace   = fs.Bool("trace", false, "expand stack traces")
+		color   = fs.String("color", "auto", "color the output: auto, always, or never")
+		showRaw = fs.Bool("raw", true, "show lines which are not JSON")
+		fields  fieldFlags
+	)
+	fs.Var(&fields, "field", "only show entries where the field equals the value ie request_id=b7ad6b71. Dotted names read nested fields. May be repeated.")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	f, err := newFilter(*level, *since, *until, *grep, fields)
+	if err != nil {
+		fmt.Fprintln(stderr, "logview:", err)
+		return 2
+	}
+
+	useColor, err := colorMode(*color, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, "logview:", err)
+		return 2
+	}
+
+	p := &printer{w: stdout, color: useColor, trace: *trace}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{logfile.Stdin}
+	}
+
+	// Following stops on interrupt
+	done := make(chan struct{})
+	if *follow {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			close(done)
+		}()
+	}
+
+	code := 0
+	for i, name := range files {
+		if err := view(name, *follow && i == len(files)-1, done, f, p, *showRaw); err != nil {
+			fmt.Fprintf(stderr, "logview: %s: %v\n", name, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// view prints the entries of a file which match the filter
+func view(name string, follow bool, done <-chan struct{}, f *filter, p *printer, showRaw bool) error {
+
+	rc, err := logfile.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if follow {
+		r = logfile.Follow(rc, done)
+	}
+
+	lines := logfile.NewReader(r)
+	for {
+		entry, line, err := lines.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if entry == nil {
+			if showRaw && f.matchRaw() {
+				p.printRaw(line)
+			}
+			continue
+		}
+
+		if f.match(entry) {
+			p.print(entry)
+		}
+	}
+}
+
+// colorMode decides if the output is colored. In auto mode it is colored when writing to a terminal.
+func colorMode(mode string, w io.Writer) (bool, error) {
+
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		f, ok := w.(*os.File)
+		if !ok {
+			return false, nil
+		}
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+
+	return false, fmt.Errorf("invalid color %q, must be auto, always, or never", mode)
+}
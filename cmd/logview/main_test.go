@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testLog = `{"level":"debug","msg":"opening file","time":"2022-03-04T15:04:05.000Z","file":"/app/uploads/handler.go","line":40,"func":"uploads.Handle","request_id":"a1"}
+{"level":"info","msg":"upload started","time":"2022-03-04T15:04:06.000Z","file":"/app/uploads/handler.go","line":42,"func":"uploads.Handle","request_id":"b7ad6b71","name":"upload.csv"}
+not json
+{"level":"error","msg":"upload failed","time":"2022-03-04T15:05:00.000Z","file":"/app/uploads/handler.go","line":50,"func":"uploads.Handle","request_id":"b7ad6b71","error":"disk full","trace":[{"file":"/app/main.go","line":12,"function":"main.main"}],"flight_recorder":[{"level":"debug","msg":"checking space","time":"2022-03-04T15:04:59.000Z","func":"uploads.check"}]}
+`
+
+func Test_run(t *testing.T) {
+
+	name := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(name, []byte(testLog), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		expLines []string
+	}{
+		{
+			name: "all",
+			expLines: []string{
+				"2022-03-04 15:04:05.000 DEBUG opening file request_id=a1  uploads/handler.go:40 uploads.Handle",
+				"2022-03-04 15:04:06.000 INFO  upload started name=upload.csv request_id=b7ad6b71  uploads/handler.go:42 uploads.Handle",
+				"not json",
+				`2022-03-04 15:05:00.000 ERROR upload failed error="disk full" request_id=b7ad6b71  uploads/handler.go:50 uploads.Handle [trace: 1 frame] [flight recorder: 1 entry]`,
+			},
+		},
+		{
+			name: "level",
+			args: []string{"-level", "warn"},
+			expLines: []string{
+				`2022-03-04 15:05:00.000 ERROR upload failed error="disk full" request_id=b7ad6b71  uploads/handler.go:50 uploads.Handle [trace: 1 frame] [flight recorder: 1 entry]`,
+			},
+		},
+		{
+			name: "field and message",
+			args: []string{"-field", "request_id=b7ad6b71", "-grep", "^upload s"},
+			expLines: []string{
+				"2022-03-04 15:04:06.000 INFO  upload started name=upload.csv request_id=b7ad6b71  uploads/handler.go:42 uploads.Handle",
+			},
+		},
+		{
+			name: "time range",
+			args: []string{"-since", "2022-03-04T15:04:06Z", "-until", "2022-03-04T15:05:00Z"},
+			expLines: []string{
+				"2022-03-04 15:04:06.000 INFO  upload started name=upload.csv request_id=b7ad6b71  uploads/handler.go:42 uploads.Handle",
+			},
+		},
+		{
+			name: "trace",
+			args: []string{"-trace", "-level", "error"},
+			expLines: []string{
+				`2022-03-04 15:05:00.000 ERROR upload failed error="disk full" request_id=b7ad6b71  uploads/handler.go:50 uploads.Handle`,
+				"    at main.main /app/main.go:12",
+				"  | 2022-03-04 15:04:59.000 DEBUG checking space  uploads.check",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			var stdout, stderr bytes.Buffer
+			code := run(append(append(tc.args, "-color", "never"), name), &stdout, &stderr)
+
+			assert.Equal(t, 0, code, stderr.String())
+			assert.Equal(t, tc.expLines, strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n"))
+		})
+	}
+}
+
+func Test_runErrors(t *testing.T) {
+
+	for _, args := range [][]string{
+		{"-level", "loud"},
+		{"-since", "yesterday"},
+		{"-grep", "("},
+		{"-field", "request_id"},
+		{"-color", "sometimes"},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, run(args, &stdout, &stderr), args)
+		assert.NotEmpty(t, stderr.String(), args)
+	}
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{filepath.Join(t.TempDir(), "missing.log")}, &stdout, &stderr))
+}
+
+func Test_parseTime(t *testing.T) {
+
+	now := time.Date(2022, 3, 4, 15, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		in  string
+		exp time.Time
+	}{
+		{in: "", exp: time.Time{}},
+		{in: "90m", exp: now.Add(-90 * time.Minute)},
+		{in: "2022-03-04T10:00:00Z", exp: time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC)},
+		{in: "2022-03-01", exp: time.Date(2022, 3, 1, 0, 0, 0, 0, time.Local)},
+	} {
+		got, err := parseTime(tc.in, now)
+		assert.NoError(t, err, tc.in)
+		assert.True(t, tc.exp.Equal(got), tc.in)
+	}
+}
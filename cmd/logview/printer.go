@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/realugbun/logger"
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+// ANSI escape codes
+const (
+	reset   = "\x1b[0m"
+	dim     = "\x1b[2m"
+	gray    = "\x1b[90m"
+	red     = "\x1b[31m"
+	yellow  = "\x1b[33m"
+	cyan    = "\x1b[36m"
+	magenta = "\x1b[1;35m"
+)
+
+var levelColors = map[string]string{
+	"trace":   gray,
+	"debug":   gray,
+	"info":    cyan,
+	"warning": yellow,
+	"error":   red,
+	"fatal":   magenta,
+	"panic":   magenta,
+}
+
+// hidden are the fields printed in their own place rather than with the custom fields
+var hidden = map[string]bool{
+	logger.FieldKeyTime:           true,
+	logger.FieldKeyLevel:          true,
+	logger.FieldKeyMsg:            true,
+	logger.FieldKeyFlightRecorder: true,
+}
+
+// callerKeys are the fields added with the caller. They are nested under the caller key when
+// FieldCollision is set to nest and custom fields can then use the same keys.
+var callerKeys = map[string]bool{
+	logger.FieldKeyFile:           true,
+	logger.FieldKeyLine:           true,
+	logger.FieldKeyFunc:           true,
+	logger.FieldKeyTrace:          true,
+	logger.FieldKeyTraceTruncated: true,
+	logger.FieldKeyGoroutines:     true,
+}
+
+// printer writes entries in a compact form ie
+// 2022-03-04 15:04:05.000 ERROR upload failed file=upload.csv  uploads/handler.go:42 uploads.Handle
+type printer struct {
+	w     io.Writer
+	color bool
+
+	// trace expands stack traces, goroutine dumps, and flight recorder entries
+	trace bool
+}
+
+func (p *printer) print(e logfile.Entry) {
+	p.printEntry(e, "")
+}
+
+// printEntry writes an entry with each line starting with indent
+func (p *printer) printEntry(e logfile.Entry, indent string) {
+
+	var sb strings.Builder
+	sb.WriteString(indent)
+
+	if t, ok := e.Time(); ok {
+		sb.WriteString(p.paint(dim, t.Format("2006-01-02 15:04:05.000")))
+	} else {
+		sb.WriteString(p.paint(dim, e.String(logger.FieldKeyTime)))
+	}
+
+	level := e.Level()
+	sb.WriteString(" " + p.paint(levelColors[level], fmt.Sprintf("%-5s", levelName(level))))
+	sb.WriteString(" " + e.Msg())
+
+	nested := e.Nested()
+
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		if hidden[k] || (nested && k == logger.FieldKeyCaller) || (!nested && callerKeys[k]) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sb.WriteString(" " + p.paint(dim, k+"=") + value(e[k]))
+	}
+
+	if c := e.ShortCaller(); c != "" {
+		sb.WriteString("  " + p.paint(gray, c))
+	}
+
+	trace := e.Trace()
+	recorded, _ := e[logger.FieldKeyFlightRecorder].([]interface{})
+
+	if !p.trace {
+		if n := frames(trace); n > 0 {
+			sb.WriteString(p.paint(dim, " [trace: "+plural(n, "frame")+"]"))
+		}
+		if len(recorded) > 0 {
+			sb.WriteString(p.paint(dim, " [flight recorder: "+plural(len(recorded), "entry")+"]"))
+		}
+	}
+
+	fmt.Fprintln(p.w, sb.String())
+
+	if !p.trace {
+		return
+	}
+
+	p.printTrace(trace, indent+"    ")
+	if g := e.Goroutines(); g != "" {
+		p.printLines(g, indent+"    ")
+	}
+	for _, r := range recorded {
+		if m, ok := r.(map[string]interface{}); ok {
+			p.printEntry(logfile.Entry(m), indent+"  | ")
+		}
+	}
+}
+
+// printTrace writes a stack trace in any of the trace formats
+func (p *printer) printTrace(trace interface{}, indent string) {
+
+	switch t := trace.(type) {
+
+	// Panic format
+	case string:
+		p.printLines(t, indent)
+
+	// Fields or compact format
+	case []interface{}:
+		for _, frame := range t {
+			switch f := frame.(type) {
+			case string:
+				fmt.Fprintln(p.w, indent+p.paint(gray, "at "+f))
+			case map[string]interface{}:
+				fr := logfile.Entry(f)
+				fmt.Fprintln(p.w, indent+p.paint(gray, "at "+fr.String("function")+" "+fr.String("file")+":"+fr.String("line")))
+			}
+		}
+	}
+}
+
+func (p *printer) printLines(s, indent string) {
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		fmt.Fprintln(p.w, indent+p.paint(gray, line))
+	}
+}
+
+// printRaw writes a line which is not JSON as it is
+func (p *printer) printRaw(line []byte) {
+	fmt.Fprintln(p.w, p.paint(dim, string(line)))
+}
+
+func (p *printer) paint(color, s string) string {
+	if !p.color || color == "" || s == "" {
+		return s
+	}
+	return color + s + reset
+}
+
+// levelName gets the upper case name of a level shortening warning to fit the column
+func levelName(level string) string {
+	if level == "warning" {
+		return "WARN"
+	}
+	return strings.ToUpper(level)
+}
+
+// frames counts the frames of a trace in any of the trace formats
+func frames(trace interface{}) int {
+	switch t := trace.(type) {
+	case string:
+		return strings.Count(t, "\n\t")
+	case []interface{}:
+		return len(t)
+	}
+	return 0
+}
+
+// plural formats a count with the noun ie 1 frame or 2 frames
+func plural(n int, noun string) string {
+	switch {
+	case n == 1:
+		return "1 " + noun
+	case strings.HasSuffix(noun, "y"):
+		return strconv.Itoa(n) + " " + strings.TrimSuffix(noun, "y") + "ies"
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
+// value formats a custom field. Strings are quoted when they contain spaces or quotes.
+func value(v interface{}) string {
+
+	switch v := v.(type) {
+	case string:
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			return strconv.Quote(v)
+		}
+		return v
+	case json.Number:
+		return v.String()
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
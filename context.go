@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	traceParentKey
+	entryKey
+)
+
+// WithRequestID returns a copy of ctx carrying the request id.
+// The id is logged and sent with outbound requests made through Transport.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID gets the request id from ctx or an empty string if it is not set
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithTraceParent returns a copy of ctx carrying a W3C traceparent header value
+// ie 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey, traceParent)
+}
+
+// TraceParent gets the W3C traceparent from ctx or an empty string if it is not set
+func TraceParent(ctx context.Context) string {
+	tp, _ := ctx.Value(traceParentKey).(string)
+	return tp
+}
+
+// NewContext returns a copy of ctx carrying a request scoped logger ie an entry with the request id and method.
+// The entry is used by FromContext.
+func NewContext(ctx context.Context, entry *Entry) context.Context {
+	return context.WithValue(ctx, entryKey, entry)
+}
+
+// FromContext gets the logger added to ctx with NewContext or the package level logger if there is none
+func FromContext(ctx context.Context) *Entry {
+	if e, ok := ctx.Value(entryKey).(*Entry); ok {
+		return e
+	}
+	return std
+}
@@ -0,0 +1,46 @@
+package logger
+
+// ECSVersion is the version of the Elastic Common Schema written when the ECS option is set
+const ECSVersion = "8.11.0"
+
+const (
+	fieldKeyECSVersion   = "ecs.version"
+	fieldKeyErrorMessage = "error.message"
+
+	// customKeyError is the custom field used for errors by the logger's own integrations
+	customKeyError = "error"
+)
+
+// ecsFieldMap names the fields written by the logger in the Elastic Common Schema
+var ecsFieldMap = FieldMap{
+	FieldKeyTime:        "@timestamp",
+	FieldKeyLevel:       "log.level",
+	FieldKeyMsg:         "message",
+	FieldKeyFile:        "log.origin.file.name",
+	FieldKeyLine:        "log.origin.file.line",
+	FieldKeyFunc:        "log.origin.function",
+	FieldKeyTrace:       "error.stack_trace",
+	FieldKeyHostname:    "host.hostname",
+	FieldKeyPID:         "process.pid",
+	FieldKeyService:     "service.name",
+	FieldKeyVersion:     "service.version",
+	FieldKeyEnvironment: "service.environment",
+}
+
+// fieldMap gets the names of the fields written by the logger. With ECS set the ECS names are used
+// for the fields FieldMap does not rename.
+func (o *Options) fieldMap() FieldMap {
+
+	if !o.GetECS() {
+		return o.FieldMap
+	}
+
+	m := make(FieldMap, len(ecsFieldMap)+len(o.FieldMap))
+	for k, v := range ecsFieldMap {
+		m[k] = v
+	}
+	for k, v := range o.FieldMap {
+		m[k] = v
+	}
+	return m
+}
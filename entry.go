@@ -0,0 +1,344 @@
+package logger
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry holds the settings for a log call such as custom fields or stack trace overrides.
+// Entries are created with WithFields, WithStack, or WithoutStack and can be reused.
+type Entry struct {
+	fields Fields
+
+	// stack overrides the StackTrace options for this entry when set
+	stack *bool
+
+	// skipPackages are skipped when finding the caller in addition to the SkipPackages option
+	skipPackages []string
+
+	// recorder keeps the Debug and Trace messages below the level instead of the FlightRecorder option when set
+	recorder *FlightRecorder
+}
+
+// std is the entry used by the package level logging functions
+var std = &Entry{}
+
+// WithFields returns an entry which adds custom fields to every message logged with it
+func WithFields(fields Fields) *Entry {
+	return std.WithFields(fields)
+}
+
+// WithStack returns an entry which always includes a stack trace regardless of the StackTrace options
+func WithStack() *Entry {
+	return std.WithStack()
+}
+
+// WithoutStack returns an entry which never includes a stack trace regardless of the StackTrace options
+func WithoutStack() *Entry {
+	return std.WithoutStack()
+}
+
+// WithFields returns a copy of the entry which adds custom fields to every message logged with it.
+// The fields are added to the entry's existing fields replacing any with the same key.
+func (e *Entry) WithFields(fields Fields) *Entry {
+	n := *e
+
+	// Copy so later changes to either map do not change the entry
+	n.fields = make(Fields, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		n.fields[k] = v
+	}
+	for k, v := range fields {
+		n.fields[k] = v
+	}
+	return &n
+}
+
+// WithStack returns a copy of the entry which always includes a stack trace
+func (e *Entry) WithStack() *Entry {
+	n := *e
+	b := true
+	n.stack = &b
+	return &n
+}
+
+// WithoutStack returns a copy of the entry which never includes a stack trace
+func (e *Entry) WithoutStack() *Entry {
+	n := *e
+	b := false
+	n.stack = &b
+	return &n
+}
+
+// AddSkipPackages returns a copy of the entry which also skips frames from the packages when finding the caller.
+// Use it when logging from code that is called through another package ie an http.RoundTripper called by net/http.
+func (e *Entry) AddSkipPackages(pkgs ...string) *Entry {
+	n := *e
+	n.skipPackages = append(append([]string(nil), e.skipPackages...), pkgs...)
+	return &n
+}
+
+// stackOptions gets the stack trace options for a message at the given level.
+// It returns nil when the message should not include a stack trace.
+func (e *Entry) stackOptions(o *Options, level logrus.Level) *StackTrace {
+
+	st := o.StackTrace
+
+	if e.stack != nil {
+		if !*e.stack {
+			return nil
+		}
+		if st == nil {
+			return NewStackTrace()
+		}
+		return st
+	}
+
+	if st == nil || !st.includesLevel(level) {
+		return nil
+	}
+
+	return st
+}
+
+// callerFields gets the caller and stack trace fields for a message at the given level
+func (e *Entry) callerFields(o *Options, level logrus.Level) logrus.Fields {
+
+	// WithStack includes the caller even when IncludeFunc is disabled
+	if !o.GetIncludeFunc() && (e.stack == nil || !*e.stack) {
+		return nil
+	}
+
+	return stackTrace(o, level, e.stackOptions(o, level), e.skipPackages...)
+}
+
+// enabled checks if a message at the level is written or kept by a flight recorder
+func (e *Entry) enabled(level logrus.Level) bool {
+	return logrus.IsLevelEnabled(level) || (level >= logrus.DebugLevel && e.flightRecorder() != nil)
+}
+
+// log writes the message with the entry's fields and the caller info at the given level.
+// Messages below the level are kept by the flight recorder and added to the next error.
+func (e *Entry) log(level logrus.Level, msg string) {
+
+	// The options are read once so a concurrent init cannot mix the old and new settings in one message
+	o := getOptions()
+
+	fields := mergeFields(o, e.fields, e.callerFields(o, level))
+
+	r := e.flightRecorder()
+
+	if !logrus.IsLevelEnabled(level) {
+		if r != nil {
+			r.record(o.getFormatter(), level, msg, fields)
+		}
+		return
+	}
+
+	if r != nil && level <= logrus.ErrorLevel {
+		if entries := r.flush(); len(entries) > 0 {
+			fields[FieldKeyFlightRecorder] = entries
+		}
+	}
+
+	logrus.WithFields(fields).Log(level, msg)
+}
+
+// sprintln formats a message like fmt.Sprintln without the trailing new line
+func sprintln(args ...interface{}) string {
+	msg := fmt.Sprintln(args...)
+	return msg[:len(msg)-1]
+}
+
+// Trace logs a message at level Trace.
+func (e *Entry) Trace(args ...interface{}) {
+	if e.enabled(logrus.TraceLevel) {
+		e.log(logrus.TraceLevel, fmt.Sprint(args...))
+	}
+}
+
+// Debug logs a message at level Debug.
+func (e *Entry) Debug(args ...interface{}) {
+	if e.enabled(logrus.DebugLevel) {
+		e.log(logrus.DebugLevel, fmt.Sprint(args...))
+	}
+}
+
+// Print logs a message at level Info.
+func (e *Entry) Print(args ...interface{}) {
+	if e.enabled(logrus.InfoLevel) {
+		e.log(logrus.InfoLevel, fmt.Sprint(args...))
+	}
+}
+
+// Info logs a message at level Info.
+func (e *Entry) Info(args ...interface{}) {
+	if e.enabled(logrus.InfoLevel) {
+		e.log(logrus.InfoLevel, fmt.Sprint(args...))
+	}
+}
+
+// Warn logs a message at level Warn.
+func (e *Entry) Warn(args ...interface{}) {
+	if e.enabled(logrus.WarnLevel) {
+		e.log(logrus.WarnLevel, fmt.Sprint(args...))
+	}
+}
+
+// Warning logs a message at level Warn.
+func (e *Entry) Warning(args ...interface{}) {
+	if e.enabled(logrus.WarnLevel) {
+		e.log(logrus.WarnLevel, fmt.Sprint(args...))
+	}
+}
+
+// Error logs a message at level Error.
+func (e *Entry) Error(args ...interface{}) {
+	if e.enabled(logrus.ErrorLevel) {
+		e.log(logrus.ErrorLevel, fmt.Sprint(args...))
+	}
+}
+
+// Panic logs a message at level Panic.
+func (e *Entry) Panic(args ...interface{}) {
+	if e.enabled(logrus.PanicLevel) {
+		e.log(logrus.PanicLevel, fmt.Sprint(args...))
+	}
+}
+
+// Fatal logs a message at level Fatal then the process will exit with status set to 1.
+func (e *Entry) Fatal(args ...interface{}) {
+	if e.enabled(logrus.FatalLevel) {
+		e.log(logrus.FatalLevel, fmt.Sprint(args...))
+	}
+	logrus.StandardLogger().Exit(1)
+}
+
+// Tracef logs a message at level Trace.
+func (e *Entry) Tracef(format string, args ...interface{}) {
+	if e.enabled(logrus.TraceLevel) {
+		e.log(logrus.TraceLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Debugf logs a message at level Debug.
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	if e.enabled(logrus.DebugLevel) {
+		e.log(logrus.DebugLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Printf logs a message at level Info.
+func (e *Entry) Printf(format string, args ...interface{}) {
+	if e.enabled(logrus.InfoLevel) {
+		e.log(logrus.InfoLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Infof logs a message at level Info.
+func (e *Entry) Infof(format string, args ...interface{}) {
+	if e.enabled(logrus.InfoLevel) {
+		e.log(logrus.InfoLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Warnf logs a message at level Warn.
+func (e *Entry) Warnf(format string, args ...interface{}) {
+	if e.enabled(logrus.WarnLevel) {
+		e.log(logrus.WarnLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Warningf logs a message at level Warn.
+func (e *Entry) Warningf(format string, args ...interface{}) {
+	if e.enabled(logrus.WarnLevel) {
+		e.log(logrus.WarnLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Errorf logs a message at level Error.
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	if e.enabled(logrus.ErrorLevel) {
+		e.log(logrus.ErrorLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Panicf logs a message at level Panic.
+func (e *Entry) Panicf(format string, args ...interface{}) {
+	if e.enabled(logrus.PanicLevel) {
+		e.log(logrus.PanicLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+// Fatalf logs a message at level Fatal then the process will exit with status set to 1.
+func (e *Entry) Fatalf(format string, args ...interface{}) {
+	if e.enabled(logrus.FatalLevel) {
+		e.log(logrus.FatalLevel, fmt.Sprintf(format, args...))
+	}
+	logrus.StandardLogger().Exit(1)
+}
+
+// Traceln logs a message at level Trace.
+func (e *Entry) Traceln(args ...interface{}) {
+	if e.enabled(logrus.TraceLevel) {
+		e.log(logrus.TraceLevel, sprintln(args...))
+	}
+}
+
+// Debugln logs a message at level Debug.
+func (e *Entry) Debugln(args ...interface{}) {
+	if e.enabled(logrus.DebugLevel) {
+		e.log(logrus.DebugLevel, sprintln(args...))
+	}
+}
+
+// Println logs a message at level Info.
+func (e *Entry) Println(args ...interface{}) {
+	if e.enabled(logrus.InfoLevel) {
+		e.log(logrus.InfoLevel, sprintln(args...))
+	}
+}
+
+// Infoln logs a message at level Info.
+func (e *Entry) Infoln(args ...interface{}) {
+	if e.enabled(logrus.InfoLevel) {
+		e.log(logrus.InfoLevel, sprintln(args...))
+	}
+}
+
+// Warnln logs a message at level Warn.
+func (e *Entry) Warnln(args ...interface{}) {
+	if e.enabled(logrus.WarnLevel) {
+		e.log(logrus.WarnLevel, sprintln(args...))
+	}
+}
+
+// Warningln logs a message at level Warn.
+func (e *Entry) Warningln(args ...interface{}) {
+	if e.enabled(logrus.WarnLevel) {
+		e.log(logrus.WarnLevel, sprintln(args...))
+	}
+}
+
+// Errorln logs a message at level Error.
+func (e *Entry) Errorln(args ...interface{}) {
+	if e.enabled(logrus.ErrorLevel) {
+		e.log(logrus.ErrorLevel, sprintln(args...))
+	}
+}
+
+// Panicln logs a message at level Panic.
+func (e *Entry) Panicln(args ...interface{}) {
+	if e.enabled(logrus.PanicLevel) {
+		e.log(logrus.PanicLevel, sprintln(args...))
+	}
+}
+
+// Fatalln logs a message at level Fatal then the process will exit with status set to 1.
+func (e *Entry) Fatalln(args ...interface{}) {
+	if e.enabled(logrus.FatalLevel) {
+		e.log(logrus.FatalLevel, sprintln(args...))
+	}
+	logrus.StandardLogger().Exit(1)
+}
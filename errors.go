@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"fmt"
+)
+
+// LevelError is returned when a log level cannot be parsed
+type LevelError struct {
+	// Option is the name of the option holding the level ie Level or StackTrace.MinLevel
+	Option string
+	Level  string
+}
+
+func (e *LevelError) Error() string {
+	return fmt.Sprintf("invalid log level %q for %s", e.Level, e.Option)
+}
+
+// FileError is returned when the log file cannot be opened
+type FileError struct {
+	File string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("unable to open log file %s: %v", e.File, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// OptionError is returned when an option has an invalid value or contradicts another option
+type OptionError struct {
+	// Option is the name of the invalid option ie StackTrace.MaxEntries
+	Option string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid option %s: %s", e.Option, e.Reason)
+}
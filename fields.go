@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/realugbun/logger/internal/audit"
+)
+
+// Keys of the fields added by the logger
+const (
+	FieldKeyTime           = "time"
+	FieldKeyLevel          = "level"
+	FieldKeyMsg            = "msg"
+	FieldKeyElapsed        = "elapsed"
+	FieldKeyFile           = "file"
+	FieldKeyLine           = "line"
+	FieldKeyFunc           = "func"
+	FieldKeyTrace          = "trace"
+	FieldKeyTraceTruncated = "trace_truncated"
+	FieldKeyGoroutines     = "goroutines"
+
+	// FieldKeyFlightRecorder holds the entries kept by a flight recorder when an error is logged
+	FieldKeyFlightRecorder = "flight_recorder"
+
+	// Added to every entry by the Audit option
+	FieldKeySeq            = audit.KeySeq
+	FieldKeyHash           = audit.KeyHash
+	FieldKeyAuditSignature = audit.KeySignature
+
+	// Process metadata added when enabled in the options
+	FieldKeyHostname      = "hostname"
+	FieldKeyPID           = "pid"
+	FieldKeyService       = "service"
+	FieldKeyVersion       = "version"
+	FieldKeyEnvironment   = "env"
+	FieldKeyGoVersion     = "go_version"
+	FieldKeyModuleVersion = "module_version"
+	FieldKeyVCSRevision   = "vcs_revision"
+
+	// Kubernetes and container metadata added when enabled in the options
+	FieldKeyPodName     = "kubernetes.pod"
+	FieldKeyNamespace   = "kubernetes.namespace"
+	FieldKeyNodeName    = "kubernetes.node"
+	FieldKeyLabels      = "kubernetes.labels"
+	FieldKeyContainerID = "container.id"
+
+	// FieldKeyCaller holds the caller fields when FieldCollision is set to nest
+	FieldKeyCaller = "caller"
+)
+
+// Policies for custom fields using the same keys as the fields added by the logger
+const (
+	// FieldCollisionPrefix renames custom fields that use a reserved key ie file becomes fields.file
+	FieldCollisionPrefix = "prefix"
+
+	// FieldCollisionNest moves the caller and trace fields under the caller key
+	// so custom fields keep their names. A custom caller field becomes fields.caller.
+	FieldCollisionNest = "nest"
+)
+
+// callerKeys are the keys of the fields added by stackTrace and the flight recorder
+var callerKeys = map[string]bool{
+	FieldKeyFile:           true,
+	FieldKeyLine:           true,
+	FieldKeyFunc:           true,
+	FieldKeyTrace:          true,
+	FieldKeyTraceTruncated: true,
+	FieldKeyGoroutines:     true,
+	FieldKeyFlightRecorder: true,
+}
+
+// mergeFields combines the custom fields with the caller fields into a new map.
+// The custom fields are never modified so the same map can be reused across calls and goroutines.
+// Custom fields using a reserved key are handled based on the FieldCollision option.
+func mergeFields(o *Options, fields Fields, caller logrus.Fields) logrus.Fields {
+
+	merged := make(logrus.Fields, len(fields)+len(caller)+1)
+
+	nest := o.GetFieldCollision() == FieldCollisionNest
+
+	for k, v := range fields {
+		if (nest && (k == FieldKeyCaller || k == FieldKeyFlightRecorder)) || (!nest && callerKeys[k]) {
+			k = "fields." + k
+		}
+		merged[k] = v
+	}
+
+	if len(caller) == 0 {
+		return merged
+	}
+
+	if nest {
+		merged[FieldKeyCaller] = caller
+		return merged
+	}
+
+	for k, v := range caller {
+		merged[k] = v
+	}
+
+	return merged
+}
@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FlightRecorder keeps the most recent Debug and Trace entries which are below the log level in memory.
+// They are added to the next Error, Fatal, or Panic entry logged with the recorder under FieldKeyFlightRecorder
+// then cleared. A recorder is used for every entry when the FlightRecorder option is set or for the entries of
+// a single request with WithFlightRecorder.
+type FlightRecorder struct {
+	mu      sync.Mutex
+	entries []map[string]interface{}
+
+	// start is the index of the oldest entry once the buffer is full
+	start int
+}
+
+// NewFlightRecorder creates a recorder which keeps the last size entries. It returns nil if size is not positive.
+func NewFlightRecorder(size int) *FlightRecorder {
+	if size <= 0 {
+		return nil
+	}
+	return &FlightRecorder{entries: make([]map[string]interface{}, 0, size)}
+}
+
+// WithFlightRecorder returns an entry which records its Debug and Trace messages in r when they are below the level
+func WithFlightRecorder(r *FlightRecorder) *Entry {
+	return std.WithFlightRecorder(r)
+}
+
+// WithFlightRecorder returns a copy of the entry which records its Debug and Trace messages in r
+// when they are below the level. Pass it to NewContext to record the messages of a request.
+func (e *Entry) WithFlightRecorder(r *FlightRecorder) *Entry {
+	n := *e
+	n.recorder = r
+	return &n
+}
+
+// flightRecorder gets the entry's recorder or the one from the options
+func (e *Entry) flightRecorder() *FlightRecorder {
+	if e.recorder != nil {
+		return e.recorder
+	}
+	r, _ := flightRecorder.Load().(*FlightRecorder)
+	return r
+}
+
+// record adds an entry replacing the oldest once the buffer is full. It is laid out by the formatter so it has
+// the same field names and time format as the entry it is added to.
+func (r *FlightRecorder) record(f *formatter, level logrus.Level, msg string, fields logrus.Fields) {
+
+	entry := f.data(time.Now(), level, msg, fields, false)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries) < cap(r.entries) {
+		r.entries = append(r.entries, entry)
+		return
+	}
+	r.entries[r.start] = entry
+	r.start = (r.start + 1) % len(r.entries)
+}
+
+// flush returns the entries oldest first and clears the buffer
+func (r *FlightRecorder) flush() []map[string]interface{} {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries) == 0 {
+		return nil
+	}
+
+	entries := make([]map[string]interface{}, 0, len(r.entries))
+	entries = append(entries, r.entries[r.start:]...)
+	entries = append(entries, r.entries[:r.start]...)
+
+	r.entries = r.entries[:0]
+	r.start = 0
+
+	return entries
+}
@@ -0,0 +1,248 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Time formats for the time field. Any other value is used as a layout for time.Format.
+const (
+	TimeFormatRFC3339     = time.RFC3339
+	TimeFormatRFC3339Nano = time.RFC3339Nano
+
+	// TimeFormatUnixMillis writes the time as milliseconds since the Unix epoch
+	TimeFormatUnixMillis = "unix_ms"
+
+	// TimeFormatUnixNanos writes the time as nanoseconds since the Unix epoch
+	TimeFormatUnixNanos = "unix_ns"
+)
+
+// processStart is used for the elapsed field. It holds a monotonic clock reading
+// so elapsed is not affected by changes to the wall clock.
+var processStart = time.Now()
+
+// FieldMap renames the fields written by the logger. The keys are the default names such as
+// FieldKeyTime or FieldKeyFile and the values are the names to use in the output.
+// A name containing dots nests the field ie "logger.caller" writes {"logger": {"caller": ...}}.
+type FieldMap map[string]string
+
+// formatter writes entries as JSON lines using the field names and layout from the options
+type formatter struct {
+	fieldMap   FieldMap
+	fieldsKey  string
+	timeFormat string
+	utc        bool
+	elapsed    bool
+
+	// metadata are the process fields added to every entry such as hostname and pid
+	metadata Fields
+
+	// static are the custom fields added to every entry
+	static Fields
+
+	// loggerKeys are the entry data keys added by the logger rather than the caller
+	loggerKeys map[string]bool
+
+	// reservedNames are the top level output names used by fields written by the logger
+	reservedNames map[string]bool
+
+	// ecs adds the ECS version and writes the custom error field as error.message
+	ecs bool
+}
+
+func newFormatter(o *Options) *formatter {
+
+	loggerKeys := callerKeys
+	if o.GetFieldCollision() == FieldCollisionNest {
+		loggerKeys = map[string]bool{FieldKeyCaller: true, FieldKeyFlightRecorder: true}
+	}
+
+	f := &formatter{
+		fieldMap:      o.fieldMap(),
+		fieldsKey:     o.GetFieldsKey(),
+		timeFormat:    o.GetTimeFormat(),
+		utc:           o.GetUTC(),
+		elapsed:       o.GetElapsed(),
+		metadata:      collectMetadata(o),
+		static:        o.StaticFields,
+		loggerKeys:    loggerKeys,
+		reservedNames: make(map[string]bool),
+		ecs:           o.GetECS(),
+	}
+
+	keys := []string{FieldKeyTime, FieldKeyLevel, FieldKeyMsg, FieldKeyElapsed}
+	for k := range loggerKeys {
+		keys = append(keys, k)
+	}
+	for k := range f.metadata {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		f.reservedNames[topLevel(f.name(k))] = true
+	}
+	if f.ecs {
+		f.reservedNames[topLevel(fieldKeyECSVersion)] = true
+	}
+
+	// The audit fields are added to the JSON after formatting so they are not renamed by FieldMap
+	if o.Audit != nil {
+		f.reservedNames[FieldKeySeq] = true
+		f.reservedNames[FieldKeyHash] = true
+	}
+
+	return f
+}
+
+// optionsFormatter lays out entries with the formatter of the options in use so the layout
+// changes with each init while logrus keeps the same formatter
+type optionsFormatter struct{}
+
+// Format renders a single log entry with the current options
+func (optionsFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return getOptions().getFormatter().Format(entry)
+}
+
+// Format renders a single log entry
+func (f *formatter) Format(entry *logrus.Entry) ([]byte, error) {
+
+	data := f.data(entry.Time, entry.Level, entry.Message, entry.Data, true)
+
+	b := entry.Buffer
+	if b == nil {
+		b = &bytes.Buffer{}
+	}
+
+	if err := json.NewEncoder(b).Encode(data); err != nil {
+		return nil, fmt.Errorf("failed to marshal fields to JSON, %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+// data lays out the fields of an entry. The process metadata, static fields, and ECS version are only added
+// when process is set as flight recorder entries are added to an entry which already has them.
+func (f *formatter) data(t time.Time, level logrus.Level, msg string, fields logrus.Fields, process bool) map[string]interface{} {
+
+	data := make(map[string]interface{}, len(fields)+3)
+
+	f.set(data, FieldKeyTime, f.formatTime(t))
+	f.set(data, FieldKeyLevel, level.String())
+	f.set(data, FieldKeyMsg, msg)
+
+	if f.elapsed {
+		f.set(data, FieldKeyElapsed, t.Sub(processStart).Nanoseconds())
+	}
+
+	if process {
+		if f.ecs {
+			setPath(data, fieldKeyECSVersion, ECSVersion)
+		}
+		for k, v := range f.metadata {
+			f.set(data, k, v)
+		}
+	}
+
+	// Custom fields go under FieldsKey if it is set
+	custom := data
+	if f.fieldsKey != "" {
+		custom = make(map[string]interface{}, len(fields)+len(f.static))
+	}
+
+	// Static fields are added first so fields passed to the log call replace them
+	if process {
+		for k, v := range f.static {
+			if f.fieldsKey == "" && f.reservedNames[k] {
+				k = "fields." + k
+			}
+			custom[k] = v
+		}
+	}
+
+	for k, v := range fields {
+
+		// Otherwise errors are ignored by encoding/json
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+
+		if f.loggerKeys[k] {
+			f.set(data, k, v)
+			continue
+		}
+
+		if f.ecs && k == customKeyError {
+			setPath(data, fieldKeyErrorMessage, v)
+			continue
+		}
+
+		// Custom fields never replace fields written by the logger
+		if f.fieldsKey == "" && f.reservedNames[k] {
+			k = "fields." + k
+		}
+		custom[k] = v
+	}
+
+	if f.fieldsKey != "" && len(custom) > 0 {
+		setPath(data, f.fieldsKey, custom)
+	}
+
+	return data
+}
+
+// formatTime formats the entry time for the time field
+func (f *formatter) formatTime(t time.Time) interface{} {
+
+	switch f.timeFormat {
+	case TimeFormatUnixMillis:
+		return t.UnixNano() / int64(time.Millisecond)
+	case TimeFormatUnixNanos:
+		return t.UnixNano()
+	}
+
+	if f.utc {
+		t = t.UTC()
+	}
+	return t.Format(f.timeFormat)
+}
+
+// set writes a field added by the logger under its output name
+func (f *formatter) set(data map[string]interface{}, key string, v interface{}) {
+	setPath(data, f.name(key), v)
+}
+
+// name gets the output name of a field added by the logger
+func (f *formatter) name(key string) string {
+	if name, ok := f.fieldMap[key]; ok {
+		return name
+	}
+	return key
+}
+
+// topLevel returns the first element of a dotted path
+func topLevel(path string) string {
+	return strings.SplitN(path, ".", 2)[0]
+}
+
+// setPath writes v to a dotted path in data creating nested objects as needed.
+// A value already in the way of the path is replaced.
+func setPath(data map[string]interface{}, path string, v interface{}) {
+
+	keys := strings.Split(path, ".")
+
+	m := data
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[k] = next
+		}
+		m = next
+	}
+
+	m[keys[len(keys)-1]] = v
+}
@@ -0,0 +1,24 @@
+module github.com/realugbun/logger/grpclogger
+
+go 1.19
+
+require (
+	github.com/realugbun/logger v0.0.0-20261019063904-e7a8f1e3e10d
+	github.com/sirupsen/logrus v1.8.1
+	github.com/stretchr/testify v1.2.2
+	google.golang.org/grpc v1.64.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
+
+// Builds against the logger in this repository during development. Replace directives are ignored by
+// consumers, who get the logger version required above.
+replace github.com/realugbun/logger => ../
@@ -0,0 +1,332 @@
+// Package grpclogger provides gRPC server and client interceptors which log each call with the logger package.
+package grpclogger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/realugbun/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRequestIDKey = "x-request-id"
+	traceParentKey      = "traceparent"
+)
+
+// skipPackages are skipped when finding the caller so it is the service code rather than the interceptors
+var skipPackages = []string{reflect.TypeOf(Options{}).PkgPath(), "google.golang.org/grpc"}
+
+// Options for the gRPC interceptors
+type Options struct {
+
+	// RequestIDKey is the metadata key the request id is read from and sent in. Defaults to x-request-id.
+	RequestIDKey *string
+
+	// SkipMethods are full method names ie /grpc.health.v1.Health/Check which are only logged when they fail
+	SkipMethods []string
+}
+
+func NewOptions() *Options {
+	return new(Options)
+}
+
+func (o *Options) SetRequestIDKey(key string) *Options {
+	o.RequestIDKey = &key
+	return o
+}
+
+func (o *Options) GetRequestIDKey() string {
+	if o.RequestIDKey == nil {
+		return defaultRequestIDKey
+	}
+	return *o.RequestIDKey
+}
+
+func (o *Options) SetSkipMethods(methods ...string) *Options {
+	o.SkipMethods = methods
+	return o
+}
+
+func (o *Options) GetSkipMethods() []string {
+	return o.SkipMethods
+}
+
+type interceptor struct {
+	requestIDKey string
+	skip         map[string]bool
+}
+
+func newInterceptor(o *Options) *interceptor {
+	i := &interceptor{
+		requestIDKey: o.GetRequestIDKey(),
+		skip:         make(map[string]bool),
+	}
+	for _, m := range o.GetSkipMethods() {
+		i.skip[m] = true
+	}
+	return i
+}
+
+// UnaryServerInterceptor logs unary calls with default options. For more options use UnaryServerInterceptorWithOptions.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return UnaryServerInterceptorWithOptions(NewOptions())
+}
+
+// UnaryServerInterceptorWithOptions logs the method, peer, status code, duration, and request id of each unary call.
+// The handler's context carries the request id and a logger with the method and request id which is got with
+// logger.FromContext. A panic in the handler is logged with a stack trace and returned as codes.Internal.
+func UnaryServerInterceptorWithOptions(o *Options) grpc.UnaryServerInterceptor {
+	i := newInterceptor(o)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+
+		start := time.Now()
+		ctx, entry := i.serverContext(ctx, info.FullMethod)
+
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(entry, r)
+			}
+			i.log(entry, "grpc request", info.FullMethod, start, err)
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor logs streams with default options. For more options use StreamServerInterceptorWithOptions.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return StreamServerInterceptorWithOptions(NewOptions())
+}
+
+// StreamServerInterceptorWithOptions logs each stream when the handler returns in the same way as
+// UnaryServerInterceptorWithOptions logs unary calls.
+func StreamServerInterceptorWithOptions(o *Options) grpc.StreamServerInterceptor {
+	i := newInterceptor(o)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+
+		start := time.Now()
+		ctx, entry := i.serverContext(ss.Context(), info.FullMethod)
+
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(entry, r)
+			}
+			i.log(entry, "grpc stream", info.FullMethod, start, err)
+		}()
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UnaryClientInterceptor logs unary calls with default options. For more options use UnaryClientInterceptorWithOptions.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return UnaryClientInterceptorWithOptions(NewOptions())
+}
+
+// UnaryClientInterceptorWithOptions logs the method, target, status code, duration, and request id of each
+// outbound unary call. The request id and traceparent set on the context with logger.WithRequestID and
+// logger.WithTraceParent are sent as metadata unless the call already has them.
+func UnaryClientInterceptorWithOptions(o *Options) grpc.UnaryClientInterceptor {
+	i := newInterceptor(o)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+		start := time.Now()
+		entry := clientEntry(ctx, method, cc.Target())
+
+		err := invoker(i.clientContext(ctx), method, req, reply, cc, opts...)
+
+		i.log(entry, "outbound grpc request", method, start, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor logs streams with default options. For more options use StreamClientInterceptorWithOptions.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return StreamClientInterceptorWithOptions(NewOptions())
+}
+
+// StreamClientInterceptorWithOptions logs each outbound stream when it ends in the same way as
+// UnaryClientInterceptorWithOptions logs unary calls. A stream ends when RecvMsg returns an error,
+// which is io.EOF for a successful stream, or when the single response of a client stream is received.
+// Streams which are not read to the end are not logged.
+func StreamClientInterceptorWithOptions(o *Options) grpc.StreamClientInterceptor {
+	i := newInterceptor(o)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+		start := time.Now()
+		entry := clientEntry(ctx, method, cc.Target())
+
+		cs, err := streamer(i.clientContext(ctx), desc, cc, method, opts...)
+		if err != nil {
+			i.log(entry, "outbound grpc stream", method, start, err)
+			return nil, err
+		}
+
+		return &clientStream{
+			ClientStream:  cs,
+			serverStreams: desc.ServerStreams,
+			done: func(err error) {
+				i.log(entry, "outbound grpc stream", method, start, err)
+			},
+		}, nil
+	}
+}
+
+// serverContext adds the request id and traceparent from the incoming metadata and a request scoped logger to ctx.
+// A request id is generated if the caller did not send one.
+func (i *interceptor) serverContext(ctx context.Context, method string) (context.Context, *logger.Entry) {
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	id := first(md, i.requestIDKey)
+	if id == "" {
+		id = newRequestID()
+	}
+	ctx = logger.WithRequestID(ctx, id)
+
+	if tp := first(md, traceParentKey); tp != "" {
+		ctx = logger.WithTraceParent(ctx, tp)
+	}
+
+	entry := logger.FromContext(ctx).WithFields(logger.Fields{"method": method, "request_id": id})
+
+	// The peer is only added to the interceptor's entry rather than the logger used by the handler
+	logged := entry
+	if p, ok := peer.FromContext(ctx); ok {
+		logged = entry.WithFields(logger.Fields{"peer": p.Addr.String()})
+	}
+
+	return logger.NewContext(ctx, entry), logged
+}
+
+// clientContext adds the request id and traceparent from ctx to the outgoing metadata
+func (i *interceptor) clientContext(ctx context.Context) context.Context {
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	if id := logger.RequestID(ctx); id != "" && len(md.Get(i.requestIDKey)) == 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, i.requestIDKey, id)
+	}
+	if tp := logger.TraceParent(ctx); tp != "" && len(md.Get(traceParentKey)) == 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, traceParentKey, tp)
+	}
+
+	return ctx
+}
+
+// clientEntry gets the entry for an outbound call. It extends the logger in ctx so calls made
+// while handling a request keep that request's fields.
+func clientEntry(ctx context.Context, method, target string) *logger.Entry {
+	fields := logger.Fields{"method": method, "target": target}
+	if id := logger.RequestID(ctx); id != "" {
+		fields["request_id"] = id
+	}
+	return logger.FromContext(ctx).WithFields(fields)
+}
+
+// log writes the outcome of a call at a level based on its status code
+func (i *interceptor) log(entry *logger.Entry, msg, method string, start time.Time, err error) {
+
+	if err == nil && i.skip[method] {
+		return
+	}
+
+	code := status.Code(err)
+
+	fields := logger.Fields{
+		"code":        code.String(),
+		"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		fields["error"] = status.Convert(err).Message()
+	}
+
+	entry = entry.AddSkipPackages(skipPackages...).WithFields(fields)
+
+	switch code {
+	case codes.OK:
+		entry.Info(msg)
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		entry.Error(msg)
+	default:
+		entry.Warn(msg)
+	}
+}
+
+// recovered logs a panic from a handler with the stack trace from where it happened.
+// The panic value is not returned to the caller as it may contain internal details.
+func recovered(entry *logger.Entry, r interface{}) error {
+
+	// Skipping the runtime starts the trace at the function which panicked rather than in the recover
+	entry.AddSkipPackages(skipPackages...).
+		AddSkipPackages("runtime").
+		WithStack().
+		WithFields(logger.Fields{"panic": fmt.Sprint(r)}).
+		Error("grpc handler panicked")
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+// serverStream replaces the context of a stream with one carrying the request scoped logger
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// clientStream calls done once when the stream ends
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	once          sync.Once
+	done          func(error)
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+
+	err := s.ClientStream.RecvMsg(m)
+
+	// A stream without server streaming has a single response
+	if err != nil || !s.serverStreams {
+		s.once.Do(func() {
+			if err == io.EOF {
+				s.done(nil)
+				return
+			}
+			s.done(err)
+		})
+	}
+
+	return err
+}
+
+// first gets the first value of a metadata key or an empty string
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// newRequestID generates a random 128 bit request id as hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,248 @@
+package grpclogger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/realugbun/logger"
+	"github.com/realugbun/logger/grpclogger"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer behaves based on the service name in the request: "panic" panics, "missing" returns NotFound,
+// and anything else logs with the request scoped logger
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+}
+
+func (healthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	switch req.Service {
+	case "panic":
+		var m map[string]int
+		m["boom"]++
+	case "missing":
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	logger.FromContext(ctx).Info("checking health")
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (healthServer) Watch(req *grpc_health_v1.HealthCheckRequest, s grpc_health_v1.Health_WatchServer) error {
+	logger.FromContext(s.Context()).Info("watching health")
+	for i := 0; i < 2; i++ {
+		if err := s.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newClient starts an in process server with the interceptors and returns a client connected to it
+func newClient(t *testing.T, o *grpclogger.Options) grpc_health_v1.HealthClient {
+
+	lis := bufconn.Listen(1 << 20)
+
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(grpclogger.UnaryServerInterceptorWithOptions(o)),
+		grpc.StreamInterceptor(grpclogger.StreamServerInterceptorWithOptions(o)),
+	)
+	grpc_health_v1.RegisterHealthServer(srv, healthServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(grpclogger.UnaryClientInterceptorWithOptions(o)),
+		grpc.WithStreamInterceptor(grpclogger.StreamClientInterceptorWithOptions(o)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+// logEntries captures the log entries written while fn runs
+func logEntries(t *testing.T, fn func()) []map[string]interface{} {
+
+	logger.InitWithOptions(logger.NewOptions().SetIncludeFunc(true))
+
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	fn()
+
+	var entries []map[string]interface{}
+	d := json.NewDecoder(&buf)
+	for {
+		var entry map[string]interface{}
+		if err := d.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// byMsg indexes entries by message
+func byMsg(entries []map[string]interface{}) map[string]map[string]interface{} {
+	m := make(map[string]map[string]interface{})
+	for _, e := range entries {
+		m[e["msg"].(string)] = e
+	}
+	return m
+}
+
+func Test_unary(t *testing.T) {
+
+	client := newClient(t, grpclogger.NewOptions())
+
+	entries := byMsg(logEntries(t, func() {
+		ctx := logger.WithRequestID(context.Background(), "b7ad6b71")
+		_, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}))
+
+	handler := entries["checking health"]
+	server := entries["grpc request"]
+	client_ := entries["outbound grpc request"]
+
+	// The request id is propagated to the server and its handler's logger
+	for _, e := range []map[string]interface{}{handler, server, client_} {
+		if assert.NotNil(t, e) {
+			assert.Equal(t, "b7ad6b71", e["request_id"])
+			assert.Equal(t, "/grpc.health.v1.Health/Check", e["method"])
+		}
+	}
+
+	assert.Equal(t, "info", server["level"])
+	assert.Equal(t, "OK", server["code"])
+	assert.Equal(t, "bufconn", server["peer"])
+	assert.Contains(t, server, "duration_ms")
+	assert.Equal(t, "grpclogger_test.healthServer.Check", handler["func"])
+
+	assert.Equal(t, "bufnet", client_["target"])
+	assert.Equal(t, "OK", client_["code"])
+}
+
+func Test_unaryErrors(t *testing.T) {
+
+	client := newClient(t, grpclogger.NewOptions())
+
+	for _, tc := range []struct {
+		service  string
+		expCode  codes.Code
+		expLevel string
+		expError string
+	}{
+		{service: "missing", expCode: codes.NotFound, expLevel: "warning", expError: "unknown service"},
+		{service: "panic", expCode: codes.Internal, expLevel: "error", expError: "internal error"},
+	} {
+		t.Run(tc.service, func(t *testing.T) {
+
+			var err error
+			entries := byMsg(logEntries(t, func() {
+				_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: tc.service})
+			}))
+
+			assert.Equal(t, tc.expCode, status.Code(err))
+
+			server := entries["grpc request"]
+			if assert.NotNil(t, server) {
+				assert.Equal(t, tc.expLevel, server["level"])
+				assert.Equal(t, tc.expCode.String(), server["code"])
+				assert.Equal(t, tc.expError, server["error"])
+
+				// A request id is generated when the client did not send one
+				assert.Len(t, server["request_id"], 32)
+			}
+		})
+	}
+}
+
+func Test_recoverPanic(t *testing.T) {
+
+	client := newClient(t, grpclogger.NewOptions())
+
+	entries := byMsg(logEntries(t, func() {
+		client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "panic"})
+	}))
+
+	entry := entries["grpc handler panicked"]
+	if !assert.NotNil(t, entry) {
+		return
+	}
+
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "assignment to entry in nil map", entry["panic"])
+
+	// The caller is the function which panicked and the trace continues from its caller
+	assert.Equal(t, "grpclogger_test.healthServer.Check", entry["func"])
+	trace, _ := entry["trace"].([]interface{})
+	if assert.NotEmpty(t, trace) {
+		assert.Equal(t, "grpc_health_v1._Health_Check_Handler.func1", trace[0].(map[string]interface{})["function"])
+	}
+}
+
+func Test_stream(t *testing.T) {
+
+	client := newClient(t, grpclogger.NewOptions())
+
+	entries := byMsg(logEntries(t, func() {
+		ctx := logger.WithRequestID(context.Background(), "b7ad6b71")
+		s, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		for {
+			if _, err := s.Recv(); err != nil {
+				assert.Equal(t, io.EOF, err)
+				break
+			}
+		}
+	}))
+
+	for _, msg := range []string{"watching health", "grpc stream", "outbound grpc stream"} {
+		if assert.NotNil(t, entries[msg], msg) {
+			assert.Equal(t, "b7ad6b71", entries[msg]["request_id"], msg)
+			assert.Equal(t, "/grpc.health.v1.Health/Watch", entries[msg]["method"], msg)
+		}
+	}
+	assert.Equal(t, "OK", entries["outbound grpc stream"]["code"])
+}
+
+func Test_skipMethods(t *testing.T) {
+
+	client := newClient(t, grpclogger.NewOptions().SetSkipMethods("/grpc.health.v1.Health/Check"))
+
+	entries := logEntries(t, func() {
+		client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "missing"})
+	})
+
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e["msg"].(string))
+	}
+
+	// Only the failed call is logged by the interceptors
+	assert.Equal(t, "checking health,grpc request,outbound grpc request", strings.Join(msgs, ","))
+}
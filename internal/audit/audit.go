@@ -0,0 +1,200 @@
+// Package audit chains log entries together with hashes so edits, deletions, and reordering can be detected.
+//
+// Each entry gets a sequence number and a hash appended to its JSON object:
+//
+//	{"level":"info","msg":"user deleted","time":"...","seq":42,"hash":"9f86d0..."}
+//
+// The hash is the SHA-256 of the previous entry's hash followed by the entry up to and including the seq field.
+// The first entry uses a zero hash as the previous hash. Signature records sign the seq and hash of an entry
+// with an ed25519 key which, through the chain, covers every entry up to it:
+//
+//	{"audit_signature":{"seq":42,"hash":"9f86d0...","key":"1a2b3c4d","signature":"..."}}
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// Keys added to entries and used by signature records
+const (
+	KeySeq       = "seq"
+	KeyHash      = "hash"
+	KeySignature = "audit_signature"
+)
+
+// resumeWindow is how much of the end of a file is read to find the last entry when resuming a chain
+const resumeWindow = 1 << 20
+
+var (
+	hashSuffix      = regexp.MustCompile(`,"` + KeyHash + `":"([0-9a-f]{64})"}$`)
+	seqSuffix       = regexp.MustCompile(`[{,]"` + KeySeq + `":(\d+)$`)
+	signaturePrefix = []byte(`{"` + KeySignature + `":`)
+)
+
+// Chain holds the sequence number and hash of the last entry
+type Chain struct {
+	Seq  uint64
+	Hash [sha256.Size]byte
+}
+
+// Append adds the next sequence number and the hash to a JSON object line without its new line
+func (c *Chain) Append(line []byte) []byte {
+
+	c.Seq++
+
+	body := bytes.TrimSuffix(line, []byte("}"))
+	sep := ","
+	if bytes.HasSuffix(body, []byte("{")) {
+		sep = ""
+	}
+
+	content := make([]byte, 0, len(body)+len(sep)+100)
+	content = append(content, body...)
+	content = append(content, sep+`"`+KeySeq+`":`+strconv.FormatUint(c.Seq, 10)...)
+
+	c.Hash = hash(c.Hash, content)
+
+	return append(content, `,"`+KeyHash+`":"`+hex.EncodeToString(c.Hash[:])+`"}`...)
+}
+
+func hash(prev [sha256.Size]byte, content []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(content)
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// Signature is the body of a signature record
+type Signature struct {
+	Seq       uint64 `json:"seq"`
+	Hash      string `json:"hash"`
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
+}
+
+// Sign creates a signature record line without its new line for the last entry of the chain
+func (c Chain) Sign(key ed25519.PrivateKey) []byte {
+
+	s := Signature{
+		Seq:       c.Seq,
+		Hash:      hex.EncodeToString(c.Hash[:]),
+		Key:       KeyID(key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, signedMessage(c.Seq, c.Hash))),
+	}
+
+	b, _ := json.Marshal(map[string]Signature{KeySignature: s})
+	return b
+}
+
+// KeyID identifies a public key in signature records
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func signedMessage(seq uint64, h [sha256.Size]byte) []byte {
+	return []byte("logger audit\n" + strconv.FormatUint(seq, 10) + "\n" + hex.EncodeToString(h[:]))
+}
+
+// record is a parsed line
+type record struct {
+	entry bool
+	seq   uint64
+	hash  [sha256.Size]byte
+
+	// content is what the entry's hash covers
+	content []byte
+
+	signature *Signature
+}
+
+var errNotAudit = errors.New("not an audit entry")
+
+// parse reads an entry or a signature record
+func parse(line []byte) (record, error) {
+
+	if bytes.HasPrefix(line, signaturePrefix) {
+		var r map[string]*Signature
+		if err := json.Unmarshal(line, &r); err != nil || r[KeySignature] == nil {
+			return record{}, errNotAudit
+		}
+		s := r[KeySignature]
+		h, err := decodeHash(s.Hash)
+		if err != nil {
+			return record{}, errNotAudit
+		}
+		return record{seq: s.Seq, hash: h, signature: s}, nil
+	}
+
+	m := hashSuffix.FindSubmatchIndex(line)
+	if m == nil {
+		return record{}, errNotAudit
+	}
+	content := line[:m[0]]
+
+	s := seqSuffix.FindSubmatch(content)
+	if s == nil {
+		return record{}, errNotAudit
+	}
+	seq, err := strconv.ParseUint(string(s[1]), 10, 64)
+	if err != nil {
+		return record{}, errNotAudit
+	}
+
+	h, _ := decodeHash(string(line[m[2]:m[3]]))
+
+	return record{entry: true, seq: seq, hash: h, content: content}, nil
+}
+
+func decodeHash(s string) ([sha256.Size]byte, error) {
+	var h [sha256.Size]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, fmt.Errorf("invalid hash %q", s)
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// Resume finds the last entry or signature record in the end of a file so a chain can continue after a restart.
+// Lines after it which are not audit entries, such as one cut off by a crash, are left for the verifier to report.
+// An empty file gives a new chain.
+func Resume(r io.ReaderAt, size int64) (Chain, error) {
+
+	start := size - resumeWindow
+	if start < 0 {
+		start = 0
+	}
+
+	buf := make([]byte, size-start)
+	if _, err := r.ReadAt(buf, start); err != nil && err != io.EOF {
+		return Chain{}, err
+	}
+
+	lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		if len(lines[i]) == 0 {
+			continue
+		}
+		if rec, err := parse(lines[i]); err == nil {
+			return Chain{Seq: rec.seq, Hash: rec.hash}, nil
+		}
+	}
+
+	if size > 0 {
+		return Chain{}, errors.New("no entry found at the end of the file")
+	}
+	return Chain{}, nil
+}
@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testChain appends n entries and signs the last one when key is set
+func testChain(n int, key ed25519.PrivateKey) ([][]byte, Chain) {
+	var (
+		c     Chain
+		lines [][]byte
+	)
+	for i := 0; i < n; i++ {
+		lines = append(lines, c.Append([]byte(`{"level":"info","msg":"user `+string(rune('a'+i))+` deleted"}`)))
+	}
+	if key != nil {
+		lines = append(lines, c.Sign(key))
+	}
+	return lines, c
+}
+
+func verify(key ed25519.PublicKey, lines [][]byte) Result {
+	v := NewVerifier(key)
+	for _, l := range lines {
+		v.Line(l)
+	}
+	return v.Result()
+}
+
+func Test_Chain(t *testing.T) {
+
+	var c Chain
+	assert.Equal(t, `{"seq":1,"hash":"`, string(c.Append([]byte("{}"))[:17]))
+
+	line := c.Append([]byte(`{"msg":"a"}`))
+	assert.True(t, strings.HasPrefix(string(line), `{"msg":"a","seq":2,"hash":"`), string(line))
+
+	rec, err := parse(line)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(2), rec.seq)
+		assert.Equal(t, c.Hash, rec.hash)
+	}
+
+	_, err = parse([]byte(`{"msg":"a"}`))
+	assert.Equal(t, errNotAudit, err)
+}
+
+func Test_Verifier(t *testing.T) {
+
+	pub, key, _ := ed25519.GenerateKey(nil)
+	otherPub, otherKey, _ := ed25519.GenerateKey(nil)
+
+	for _, tc := range []struct {
+		name     string
+		key      ed25519.PublicKey
+		change   func([][]byte) [][]byte
+		problems []string
+		unsigned int
+	}{
+		{
+			name:   "unchanged",
+			key:    pub,
+			change: func(l [][]byte) [][]byte { return l },
+		},
+		{
+			name:     "no key",
+			change:   func(l [][]byte) [][]byte { return l },
+			unsigned: 3,
+		},
+		{
+			name: "edited",
+			key:  pub,
+			change: func(l [][]byte) [][]byte {
+				l[1] = bytes.Replace(l[1], []byte("user b"), []byte("user x"), 1)
+				return l
+			},
+			problems: []string{"line 2: seq 2: hash does not match, the entry was edited"},
+		},
+		{
+			name: "deleted",
+			key:  pub,
+			change: func(l [][]byte) [][]byte {
+				return append(l[:1], l[2:]...)
+			},
+			problems: []string{"line 2: seq 3: entries 2 to 2 are missing"},
+		},
+		{
+			name: "reordered",
+			key:  pub,
+			change: func(l [][]byte) [][]byte {
+				l[1], l[2] = l[2], l[1]
+				return l
+			},
+			problems: []string{
+				"line 2: seq 3: entries 2 to 2 are missing",
+				"line 3: seq 2: seq after 3, the entry was moved or repeated",
+				"line 4: seq 3: signature does not match the last entry, seq 2",
+			},
+			unsigned: 3,
+		},
+		{
+			name: "removed from the end",
+			key:  pub,
+			change: func(l [][]byte) [][]byte {
+				return append(l[:2], l[3])
+			},
+			problems: []string{"line 3: seq 3: signature does not match the last entry, seq 2"},
+			unsigned: 2,
+		},
+		{
+			name: "first entry removed",
+			key:  pub,
+			change: func(l [][]byte) [][]byte {
+				return l[1:]
+			},
+			problems: []string{"line 1: seq 2: chain starts at seq 2, earlier entries are in another file or were removed"},
+		},
+		{
+			name: "line added",
+			key:  pub,
+			change: func(l [][]byte) [][]byte {
+				return append([][]byte{[]byte(`{"msg":"added"}`)}, l...)
+			},
+			problems: []string{"line 1: not an audit entry, the line was added or edited"},
+		},
+		{
+			name: "different key",
+			key:  otherPub,
+			change: func(l [][]byte) [][]byte {
+				return l
+			},
+			problems: []string{"line 4: seq 3: signed with a different key " + KeyID(pub)},
+			unsigned: 3,
+		},
+		{
+			name: "forged signature",
+			key:  pub,
+			change: func(l [][]byte) [][]byte {
+				c := Chain{Seq: 3}
+				c.Hash, _ = decodeHash(string(l[2][len(l[2])-66 : len(l[2])-2]))
+				sig := c.Sign(otherKey)
+				l[3] = bytes.Replace(sig, []byte(KeyID(otherPub)), []byte(KeyID(pub)), 1)
+				return l
+			},
+			problems: []string{"line 4: seq 3: invalid signature"},
+			unsigned: 3,
+		},
+	} {
+		lines, _ := testChain(3, key)
+		r := verify(tc.key, tc.change(lines))
+
+		var problems []string
+		for _, p := range r.Problems {
+			problems = append(problems, p.String())
+		}
+		assert.Equal(t, tc.problems, problems, tc.name)
+		assert.Equal(t, tc.unsigned, r.Unsigned, tc.name)
+	}
+}
+
+func Test_Resume(t *testing.T) {
+
+	_, key, _ := ed25519.GenerateKey(nil)
+
+	lines, c := testChain(3, key)
+	log := append(bytes.Join(lines, []byte("\n")), []byte("\n{\"msg\":\"cut off")...)
+
+	got, err := Resume(bytes.NewReader(log), int64(len(log)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, c, got)
+	}
+
+	got, err = Resume(bytes.NewReader(nil), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Chain{}, got)
+
+	_, err = Resume(strings.NewReader("not audit\n"), 10)
+	assert.Error(t, err)
+
+	// Entries appended after resuming continue the chain
+	next := c
+	lines = append(lines, next.Append([]byte(`{"msg":"after restart"}`)))
+	assert.Empty(t, verify(nil, lines).Problems)
+}
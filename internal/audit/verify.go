@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+)
+
+// Problem is something found by the verifier which shows the log was changed
+type Problem struct {
+	File string
+
+	// Line is the line number in the file starting from 1
+	Line int
+	Seq  uint64
+	Msg  string
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("line %d: ", p.Line)
+	if p.File != "" {
+		s = p.File + ":" + s[len("line "):]
+	}
+	if p.Seq != 0 {
+		s += fmt.Sprintf("seq %d: ", p.Seq)
+	}
+	return s + p.Msg
+}
+
+// Result summarizes a verification
+type Result struct {
+	Entries    int
+	Signatures int
+
+	// LastSigned is the seq of the last entry covered by a valid signature
+	LastSigned uint64
+
+	// Unsigned is the number of entries after the last valid signature
+	Unsigned int
+
+	Problems []Problem
+}
+
+// Verifier checks lines in order, continuing the chain across rotated files
+type Verifier struct {
+	key ed25519.PublicKey
+
+	file    string
+	line    int
+	chain   Chain
+	started bool
+	result  Result
+}
+
+// NewVerifier creates a verifier. Signatures are checked with key when it is set.
+func NewVerifier(key ed25519.PublicKey) *Verifier {
+	return &Verifier{key: key}
+}
+
+// StartFile sets the name and restarts the line numbers used in problems for the lines of the next file
+func (v *Verifier) StartFile(name string) {
+	v.file = name
+	v.line = 0
+}
+
+func (v *Verifier) problem(seq uint64, format string, args ...interface{}) {
+	v.result.Problems = append(v.result.Problems, Problem{File: v.file, Line: v.line, Seq: seq, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Line checks the next line without its new line
+func (v *Verifier) Line(line []byte) {
+
+	v.line++
+
+	rec, err := parse(line)
+	if err != nil {
+		v.problem(0, "%v, the line was added or edited", err)
+		return
+	}
+
+	if rec.signature != nil {
+		v.signature(rec)
+		return
+	}
+
+	v.result.Entries++
+	v.result.Unsigned++
+
+	switch {
+
+	// A file which is not the first of a rotated log starts part way through the chain
+	case !v.started && rec.seq != 1:
+		v.problem(rec.seq, "chain starts at seq %d, earlier entries are in another file or were removed", rec.seq)
+
+	case rec.seq > v.chain.Seq+1:
+		v.problem(rec.seq, "entries %d to %d are missing", v.chain.Seq+1, rec.seq-1)
+
+	case rec.seq <= v.chain.Seq:
+		v.problem(rec.seq, "seq after %d, the entry was moved or repeated", v.chain.Seq)
+
+	case hash(v.chain.Hash, rec.content) != rec.hash:
+		v.problem(rec.seq, "hash does not match, the entry was edited")
+	}
+
+	// Continue from this entry so each change is only reported once
+	v.started = true
+	v.chain = Chain{Seq: rec.seq, Hash: rec.hash}
+}
+
+// signature checks a signature record covers the last entry and was made by the key
+func (v *Verifier) signature(rec record) {
+
+	v.result.Signatures++
+
+	if rec.seq != v.chain.Seq || rec.hash != v.chain.Hash {
+		v.problem(rec.seq, "signature does not match the last entry, seq %d", v.chain.Seq)
+		return
+	}
+
+	if v.key == nil {
+		return
+	}
+
+	if rec.signature.Key != KeyID(v.key) {
+		v.problem(rec.seq, "signed with a different key %s", rec.signature.Key)
+		return
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(rec.signature.Signature)
+	if err != nil || !ed25519.Verify(v.key, signedMessage(rec.seq, rec.hash), sig) {
+		v.problem(rec.seq, "invalid signature")
+		return
+	}
+
+	v.result.LastSigned = rec.seq
+	v.result.Unsigned = 0
+}
+
+// Result gets the outcome of the lines checked so far
+func (v *Verifier) Result() Result {
+	return v.result
+}
@@ -0,0 +1,262 @@
+// Package logfile reads the JSON lines written by the logger for the command line tools and sinks
+package logfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/realugbun/logger"
+)
+
+// Stdin is the file name used for standard input
+const Stdin = "-"
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// pollInterval is how often a followed file is checked for new lines
+var pollInterval = 250 * time.Millisecond
+
+// Entry is a decoded log line
+type Entry map[string]interface{}
+
+// Time gets the time of the entry. It reads RFC3339 strings and the unix_ms and unix_ns formats.
+func (e Entry) Time() (time.Time, bool) {
+
+	switch v := e[logger.FieldKeyTime].(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		// Milliseconds since the epoch stay below 1e15 until the year 33658
+		if n < 1e15 {
+			return time.Unix(0, n*int64(time.Millisecond)), true
+		}
+		return time.Unix(0, n), true
+	}
+
+	return time.Time{}, false
+}
+
+// Level gets the level of the entry
+func (e Entry) Level() string {
+	return e.String(logger.FieldKeyLevel)
+}
+
+// Msg gets the message of the entry
+func (e Entry) Msg() string {
+	return e.String(logger.FieldKeyMsg)
+}
+
+// Caller gets the file, line, and function of the caller
+func (e Entry) Caller() (file string, line int, function string) {
+
+	fields := e.callerFields()
+
+	file = fields.String(logger.FieldKeyFile)
+	function = fields.String(logger.FieldKeyFunc)
+	if n, ok := fields[logger.FieldKeyLine].(json.Number); ok {
+		l, _ := n.Int64()
+		line = int(l)
+	}
+
+	return file, line, function
+}
+
+// ShortCaller formats the caller with the file shortened to its directory and name ie uploads/handler.go:42 uploads.Handle
+func (e Entry) ShortCaller() string {
+
+	file, line, function := e.Caller()
+
+	if file != "" {
+		dir, name := path.Split(file)
+		if dir = path.Base(strings.TrimSuffix(dir, "/")); dir != "." && dir != "/" {
+			name = dir + "/" + name
+		}
+		if line > 0 {
+			name += ":" + strconv.Itoa(line)
+		}
+		file = name
+	}
+
+	return strings.TrimSpace(file + " " + function)
+}
+
+// Trace gets the stack trace of the entry or nil if it has none
+func (e Entry) Trace() interface{} {
+	return e.callerFields()[logger.FieldKeyTrace]
+}
+
+// Goroutines gets the stacks of every goroutine added to Panic and Fatal entries
+func (e Entry) Goroutines() string {
+	return e.callerFields().String(logger.FieldKeyGoroutines)
+}
+
+// Nested checks if the caller fields are nested under the caller key because FieldCollision is set to nest
+func (e Entry) Nested() bool {
+	_, ok := e[logger.FieldKeyCaller].(map[string]interface{})
+	return ok
+}
+
+// callerFields gets the top level fields or the caller object when the caller fields are nested
+func (e Entry) callerFields() Entry {
+	if nested, ok := e[logger.FieldKeyCaller].(map[string]interface{}); ok {
+		return Entry(nested)
+	}
+	return e
+}
+
+// Field gets a field by its name or by a dotted path through nested objects ie kubernetes.pod
+func (e Entry) Field(path string) (interface{}, bool) {
+
+	if v, ok := e[path]; ok {
+		return v, true
+	}
+
+	var v interface{} = map[string]interface{}(e)
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// String gets a field as a string. Values which are not strings are formatted as JSON.
+func (e Entry) String(key string) string {
+	v, _ := e.Field(key)
+	return format(v)
+}
+
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// Open opens a log file for reading. Files compressed with gzip such as rotated logs are decompressed.
+// The name "-" opens standard input.
+func Open(name string) (io.ReadCloser, error) {
+
+	var f io.ReadCloser = os.Stdin
+	if name != Stdin {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		f = file
+	}
+
+	return decompress(f)
+}
+
+// decompress checks the start of r for the gzip header
+func decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil || !bytes.Equal(magic, gzipMagic) {
+		return readCloser{br, rc}, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return readCloser{zr, rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Follow returns a reader which waits for more data at the end of the file like tail -f.
+// It returns io.EOF once done is closed.
+func Follow(r io.Reader, done <-chan struct{}) io.Reader {
+	return &follower{r: r, done: done}
+}
+
+type follower struct {
+	r    io.Reader
+	done <-chan struct{}
+}
+
+func (f *follower) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		select {
+		case <-f.done:
+			return 0, io.EOF
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Reader reads entries from JSON lines
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next reads the next line. The entry is nil when the line is not a JSON object
+// such as output from another program, in which case only the line is returned.
+// It returns io.EOF when there are no more lines.
+func (r *Reader) Next() (Entry, []byte, error) {
+
+	line, err := r.r.ReadBytes('\n')
+	if len(line) == 0 {
+		return nil, nil, err
+	}
+	line = bytes.TrimRight(line, "\r\n")
+
+	entry, _ := Parse(line)
+	return entry, line, nil
+}
+
+// Parse decodes a line. Numbers are kept as json.Number.
+func Parse(line []byte) (Entry, bool) {
+
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+
+	var entry Entry
+	if d.Decode(&entry) != nil {
+		return nil, false
+	}
+	return entry, true
+}
@@ -0,0 +1,121 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Reader(t *testing.T) {
+
+	lines := strings.Join([]string{
+		`{"level":"info","msg":"started","time":"2022-03-04T15:04:05.123Z","file":"/app/main.go","line":12,"func":"main.main"}`,
+		`panic: something else wrote this`,
+		`{"level":"error","msg":"failed","time":1646406245123,"caller":{"file":"/app/upload.go","line":40,"func":"main.upload","trace":[]},"kubernetes":{"pod":"api-1"}}`,
+	}, "\n")
+
+	for _, tc := range []struct {
+		name string
+		gzip bool
+	}{
+		{name: "plain"},
+		{name: "gzip", gzip: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			name := filepath.Join(t.TempDir(), "app.log")
+			f, err := os.Create(name)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var w io.WriteCloser = f
+			if tc.gzip {
+				w = gzip.NewWriter(f)
+			}
+			io.WriteString(w, lines)
+			w.Close()
+			f.Close()
+
+			rc, err := Open(name)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer rc.Close()
+
+			r := NewReader(rc)
+
+			entry, _, err := r.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, "started", entry.Msg())
+			ts, ok := entry.Time()
+			assert.True(t, ok)
+			assert.Equal(t, time.Date(2022, 3, 4, 15, 4, 5, 123000000, time.UTC), ts.UTC())
+			file, line, function := entry.Caller()
+			assert.Equal(t, []interface{}{"/app/main.go", 12, "main.main"}, []interface{}{file, line, function})
+			assert.False(t, entry.Nested())
+
+			entry, raw, err := r.Next()
+			assert.NoError(t, err)
+			assert.Nil(t, entry)
+			assert.Equal(t, "panic: something else wrote this", string(raw))
+
+			entry, _, err = r.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, "error", entry.Level())
+			ts, ok = entry.Time()
+			assert.True(t, ok)
+			assert.Equal(t, time.Date(2022, 3, 4, 15, 4, 5, 123000000, time.UTC), ts.UTC())
+			file, line, function = entry.Caller()
+			assert.Equal(t, []interface{}{"/app/upload.go", 40, "main.upload"}, []interface{}{file, line, function})
+			assert.True(t, entry.Nested())
+			assert.Equal(t, []interface{}{}, entry.Trace())
+			assert.Equal(t, "api-1", entry.String("kubernetes.pod"))
+
+			_, _, err = r.Next()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func Test_Follow(t *testing.T) {
+
+	pollInterval = time.Millisecond
+
+	name := filepath.Join(t.TempDir(), "app.log")
+	f, err := os.Create(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	rc, err := Open(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rc.Close()
+
+	done := make(chan struct{})
+	r := NewReader(Follow(rc, done))
+
+	go func() {
+		f.WriteString(`{"msg":"one"}` + "\n")
+		time.Sleep(10 * time.Millisecond)
+		f.WriteString(`{"msg":"two"}` + "\n")
+	}()
+
+	for _, exp := range []string{"one", "two"} {
+		entry, _, err := r.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, exp, entry.Msg())
+	}
+
+	close(done)
+	_, _, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
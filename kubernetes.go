@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default locations of the Kubernetes and container metadata
+const (
+	// DefaultPodInfoDir is where a downward API volume with the pod labels is commonly mounted
+	DefaultPodInfoDir = "/etc/podinfo"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// containerIDPattern matches the 64 character ids used by Docker, containerd, and CRI-O
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// podEnv reads the environment variables and files describing the pod and container.
+// root is prepended to every file path so tests can use fixture files.
+type podEnv struct {
+	getenv     func(string) string
+	root       string
+	podInfoDir string
+}
+
+// kubernetesFields gets the pod and container metadata when running in Kubernetes or a container.
+// Pod fields come from the downward API environment variables POD_NAME, POD_NAMESPACE, and NODE_NAME
+// and the labels file in the pod info directory.
+func (e podEnv) kubernetesFields() Fields {
+
+	fields := Fields{}
+
+	if id := e.containerID(); id != "" {
+		fields[FieldKeyContainerID] = id
+	}
+
+	if !e.inKubernetes() {
+		return fields
+	}
+
+	// The hostname of a pod is its name unless the pod spec sets a different one
+	if pod := e.env("POD_NAME", "HOSTNAME"); pod != "" {
+		fields[FieldKeyPodName] = pod
+	}
+
+	namespace := e.env("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = strings.TrimSpace(e.readFile(filepath.Join(serviceAccountDir, "namespace")))
+	}
+	if namespace != "" {
+		fields[FieldKeyNamespace] = namespace
+	}
+
+	if node := e.env("NODE_NAME"); node != "" {
+		fields[FieldKeyNodeName] = node
+	}
+
+	if labels := parseLabels(e.readFile(filepath.Join(e.podInfoDir, "labels"))); len(labels) > 0 {
+		fields[FieldKeyLabels] = labels
+	}
+
+	return fields
+}
+
+// inKubernetes checks for the variables Kubernetes sets in every container or a mounted service account
+func (e podEnv) inKubernetes() bool {
+	if e.getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+	_, err := os.Stat(e.path(serviceAccountDir))
+	return err == nil
+}
+
+// containerID finds the id of the container from the cgroup the process belongs to.
+// With cgroup v2 and a private cgroup namespace the id is not in the cgroup path
+// so the mounts are checked for the container's hostname file instead.
+func (e podEnv) containerID() string {
+
+	for _, f := range []string{"/proc/self/cgroup", "/proc/self/mountinfo"} {
+		for _, line := range strings.Split(e.readFile(f), "\n") {
+			if f == "/proc/self/mountinfo" && !strings.Contains(line, "/containers/") {
+				continue
+			}
+			if id := containerIDPattern.FindString(line); id != "" {
+				return id
+			}
+		}
+	}
+
+	return ""
+}
+
+// env returns the value of the first environment variable which is set
+func (e podEnv) env(names ...string) string {
+	for _, name := range names {
+		if v := e.getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (e podEnv) path(name string) string {
+	return filepath.Join(e.root, name)
+}
+
+// readFile returns the contents of a file or an empty string if it cannot be read
+func (e podEnv) readFile(name string) string {
+	b, err := os.ReadFile(e.path(name))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// parseLabels reads labels in the downward API file format where each line is key="value"
+func parseLabels(data string) map[string]string {
+
+	labels := make(map[string]string)
+
+	s := bufio.NewScanner(strings.NewReader(data))
+	for s.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(s.Text()), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.Unquote(kv[1])
+		if err != nil {
+			v = kv[1]
+		}
+		labels[kv[0]] = v
+	}
+
+	return labels
+}
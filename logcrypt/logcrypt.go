@@ -0,0 +1,268 @@
+// Package logcrypt encrypts log files at rest with AES-GCM and reads them back.
+//
+// A file is a series of frames. Each frame is a type byte, a 4 byte big endian payload length, and the payload.
+// A header frame starts each run of entries written with one key and holds the format version and the key id.
+// Each data frame holds a random nonce followed by one sealed write, normally a single entry, so a crash loses
+// at most the entry being written:
+//
+//	'H' len version keyID
+//	'D' len nonce ciphertext+tag
+//	'D' len nonce ciphertext+tag
+//
+// The type and length are authenticated with each data frame. Files can be appended to by later processes,
+// including ones with a different key, which start with a new header frame.
+package logcrypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	frameHeader = 'H'
+	frameData   = 'D'
+
+	version = 1
+
+	// prefixSize is the type byte and payload length before each payload
+	prefixSize = 5
+
+	// MaxChunk is the most plaintext sealed in one data frame. Larger writes are split.
+	MaxChunk = 64 << 10
+
+	// maxPayload rejects lengths which cannot have been written so a damaged file is not read into memory
+	maxPayload = MaxChunk + 64
+)
+
+var (
+	// ErrTruncated is returned when a file ends part way through a frame such as after a crash
+	ErrTruncated = errors.New("logcrypt: file ends part way through a frame")
+
+	// ErrNotEncrypted is returned when a file does not start with a header frame
+	ErrNotEncrypted = errors.New("logcrypt: not an encrypted log")
+)
+
+// FrameError is returned when a frame cannot be read or decrypted
+type FrameError struct {
+	// Offset is the position of the frame in the file
+	Offset int64
+	Err    error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("logcrypt: frame at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// KeySource provides the key when the log file is opened. Keys are 16, 24, or 32 bytes for AES-128, AES-192, or AES-256.
+type KeySource func() ([]byte, error)
+
+// StaticKey uses a key already loaded ie from a secret store
+func StaticKey(key []byte) KeySource {
+	return func() ([]byte, error) {
+		return key, nil
+	}
+}
+
+// KeyFromEnv reads a hex or base64 key from an environment variable
+func KeyFromEnv(name string) KeySource {
+	return func() ([]byte, error) {
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("logcrypt: environment variable %s is not set", name)
+		}
+		return ParseKey(s)
+	}
+}
+
+// KeyFromFile reads a hex or base64 key from a file ie a mounted secret
+func KeyFromFile(name string) KeySource {
+	return func() ([]byte, error) {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKey(string(b))
+	}
+}
+
+// ParseKey decodes a hex or base64 key
+func ParseKey(s string) ([]byte, error) {
+
+	s = strings.TrimSpace(s)
+
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("logcrypt: key is not hex or base64")
+		}
+	}
+
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func checkKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("logcrypt: key is %d bytes, must be 16, 24, or 32", len(key))
+}
+
+// GenerateKey creates a random AES-256 key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// KeyID identifies a key in header frames without revealing it
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func prefix(typ byte, n int) []byte {
+	p := make([]byte, prefixSize)
+	p[0] = typ
+	binary.BigEndian.PutUint32(p[1:], uint32(n))
+	return p
+}
+
+// Writer encrypts each write into a data frame
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	aead cipher.AEAD
+}
+
+// NewWriter writes a header frame to w then returns a writer which encrypts with key
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := hex.DecodeString(KeyID(key))
+	header := append(prefix(frameHeader, 1+len(id)), version)
+	if _, err := w.Write(append(header, id...)); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w, aead: aead}, nil
+}
+
+// Write seals p into one data frame, or several if it is longer than MaxChunk. Each frame is written
+// with a single call to the underlying writer.
+func (w *Writer) Write(p []byte) (int, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for written := 0; written < len(p); {
+
+		chunk := p[written:]
+		if len(chunk) > MaxChunk {
+			chunk = chunk[:MaxChunk]
+		}
+
+		size := w.aead.NonceSize() + len(chunk) + w.aead.Overhead()
+		frame := make([]byte, prefixSize+w.aead.NonceSize(), prefixSize+size)
+		copy(frame, prefix(frameData, size))
+
+		nonce := frame[prefixSize:]
+		if _, err := rand.Read(nonce); err != nil {
+			return written, err
+		}
+		frame = w.aead.Seal(frame, nonce, chunk, frame[:prefixSize])
+
+		if _, err := w.w.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+
+	return len(p), nil
+}
+
+// TrimPartial removes a frame cut off by a crash from the end of a file so new frames can be appended after it.
+// It returns the number of bytes removed, or ErrNotEncrypted if the file has content which is not encrypted.
+// Only a frame with a valid prefix is removed. A damaged prefix returns a *FrameError and the file is not changed
+// so the frames after it are not lost.
+func TrimPartial(f *os.File) (int64, error) {
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, 0, info.Size()))
+
+	var offset int64
+	for offset < info.Size() {
+
+		// A prefix cut off by a crash is checked as far as it was written
+		p, err := r.Peek(prefixSize)
+		if len(p) == 0 {
+			return 0, err
+		}
+		if offset == 0 && p[0] != frameHeader {
+			return 0, ErrNotEncrypted
+		}
+		if p[0] != frameHeader && p[0] != frameData {
+			return 0, &FrameError{Offset: offset, Err: fmt.Errorf("unknown frame type %q", p[0])}
+		}
+		if len(p) < prefixSize {
+			break
+		}
+
+		size := binary.BigEndian.Uint32(p[1:])
+		if size > maxPayload {
+			return 0, &FrameError{Offset: offset, Err: fmt.Errorf("frame length %d is too long", size)}
+		}
+
+		n := int64(prefixSize) + int64(size)
+		if offset+n > info.Size() {
+			break
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			return 0, err
+		}
+		offset += n
+	}
+
+	if offset == info.Size() {
+		return 0, nil
+	}
+	return info.Size() - offset, f.Truncate(offset)
+}
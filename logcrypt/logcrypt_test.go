@@ -0,0 +1,211 @@
+package logcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func Test_roundTrip(t *testing.T) {
+
+	var file bytes.Buffer
+
+	// A second writer appends with a rotated key
+	large := strings.Repeat("x", MaxChunk+10)
+	for _, tc := range []struct {
+		key     []byte
+		entries []string
+	}{
+		{key: testKey(1), entries: []string{`{"msg":"a"}` + "\n", `{"msg":"b"}` + "\n"}},
+		{key: testKey(2), entries: []string{`{"msg":"` + large + `"}` + "\n"}},
+	} {
+		w, err := NewWriter(&file, tc.key)
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, e := range tc.entries {
+			n, err := w.Write([]byte(e))
+			assert.NoError(t, err)
+			assert.Equal(t, len(e), n)
+		}
+	}
+
+	assert.NotContains(t, file.String(), `"msg"`)
+
+	r, err := NewReader(bytes.NewReader(file.Bytes()), testKey(1), testKey(2))
+	if !assert.NoError(t, err) {
+		return
+	}
+	plain, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"msg":"a"}`+"\n"+`{"msg":"b"}`+"\n"+`{"msg":"`+large+`"}`+"\n", string(plain))
+
+	// Entries before the run with the missing key are read
+	r, _ = NewReader(bytes.NewReader(file.Bytes()), testKey(1))
+	plain, err = io.ReadAll(r)
+	assert.Equal(t, `{"msg":"a"}`+"\n"+`{"msg":"b"}`+"\n", string(plain))
+
+	var fe *FrameError
+	if assert.True(t, errors.As(err, &fe)) {
+		assert.Contains(t, fe.Error(), "encrypted with key "+KeyID(testKey(2)))
+	}
+}
+
+func Test_Reader(t *testing.T) {
+
+	var file bytes.Buffer
+	w, _ := NewWriter(&file, testKey(1))
+	w.Write([]byte("first\n"))
+	w.Write([]byte("second\n"))
+	b := file.Bytes()
+
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expPlain string
+		expErr   error
+	}{
+		{
+			name:     "truncated",
+			data:     b[:len(b)-3],
+			expPlain: "first\n",
+			expErr:   ErrTruncated,
+		},
+		{
+			name:     "changed",
+			data:     append(append([]byte(nil), b[:len(b)-1]...), b[len(b)-1]^1),
+			expPlain: "first\n",
+			expErr:   &FrameError{},
+		},
+		{
+			name:   "not encrypted",
+			data:   []byte(`{"msg":"a"}` + "\n"),
+			expErr: ErrNotEncrypted,
+		},
+		{
+			name: "empty",
+		},
+	} {
+		r, _ := NewReader(bytes.NewReader(tc.data), testKey(1))
+		plain, err := io.ReadAll(r)
+		assert.Equal(t, tc.expPlain, string(plain), tc.name)
+
+		switch tc.expErr.(type) {
+		case nil:
+			assert.NoError(t, err, tc.name)
+		case *FrameError:
+			assert.IsType(t, tc.expErr, err, tc.name)
+		default:
+			assert.Equal(t, tc.expErr, err, tc.name)
+		}
+	}
+}
+
+func Test_TrimPartial(t *testing.T) {
+
+	name := filepath.Join(t.TempDir(), "app.log")
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	w, _ := NewWriter(f, testKey(1))
+	w.Write([]byte("first\n"))
+	info, _ := f.Stat()
+
+	// A crash part way through the second entry
+	f.Write([]byte{frameData, 0, 0, 0, 40, 1, 2, 3})
+
+	n, err := TrimPartial(f)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), n)
+
+	n, err = TrimPartial(f)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	after, _ := f.Stat()
+	assert.Equal(t, info.Size(), after.Size())
+
+	w, _ = NewWriter(f, testKey(1))
+	w.Write([]byte("second\n"))
+
+	f.Seek(0, io.SeekStart)
+	r, _ := NewReader(f, testKey(1))
+	plain, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(plain))
+
+	// A damaged length in the middle is not mistaken for a crash so the frames after it are kept
+	for _, prefix := range [][]byte{{frameData, 0xff, 0, 0, 0}, {'X', 0, 0, 0, 1}} {
+		damaged, _ := os.Create(filepath.Join(t.TempDir(), "damaged.log"))
+		w, _ := NewWriter(damaged, testKey(1))
+		w.Write([]byte("first\n"))
+		at, _ := damaged.Seek(0, io.SeekCurrent)
+		w.Write([]byte("second\n"))
+		w.Write([]byte("third\n"))
+		damaged.WriteAt(prefix, at)
+		before, _ := damaged.Stat()
+
+		_, err = TrimPartial(damaged)
+		var fe *FrameError
+		if assert.True(t, errors.As(err, &fe), prefix) {
+			assert.Equal(t, at, fe.Offset)
+		}
+		after, _ := damaged.Stat()
+		assert.Equal(t, before.Size(), after.Size())
+		damaged.Close()
+	}
+
+	plainFile, _ := os.Create(filepath.Join(t.TempDir(), "plain.log"))
+	defer plainFile.Close()
+	plainFile.WriteString(`{"msg":"a"}` + "\n")
+	_, err = TrimPartial(plainFile)
+	assert.Equal(t, ErrNotEncrypted, err)
+}
+
+func Test_ParseKey(t *testing.T) {
+
+	key := testKey(7)
+
+	for _, tc := range []struct {
+		in     string
+		exp    []byte
+		expErr bool
+	}{
+		{in: hex.EncodeToString(key) + "\n", exp: key},
+		{in: base64.StdEncoding.EncodeToString(key[:16]), exp: key[:16]},
+		{in: hex.EncodeToString(key[:20]), expErr: true},
+		{in: "not a key", expErr: true},
+	} {
+		got, err := ParseKey(tc.in)
+		if tc.expErr {
+			assert.Error(t, err, tc.in)
+			continue
+		}
+		assert.NoError(t, err, tc.in)
+		assert.Equal(t, tc.exp, got, tc.in)
+	}
+
+	os.Setenv("Test_ParseKey", hex.EncodeToString(key))
+	defer os.Unsetenv("Test_ParseKey")
+	got, err := KeyFromEnv("Test_ParseKey")()
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	_, err = KeyFromEnv("Test_ParseKey_missing")()
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package logcrypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reader decrypts a file written by Writer into the plaintext entries
+type Reader struct {
+	r *bufio.Reader
+
+	// keys are the AEADs of the keys given to NewReader by key id
+	keys map[string]cipher.AEAD
+
+	// aead decrypts the data frames after the last header
+	aead cipher.AEAD
+
+	// offset is the position of the next frame
+	offset int64
+
+	// plain is the decrypted data not yet read
+	plain []byte
+	err   error
+}
+
+// NewReader reads the encrypted file in r. Each run of entries is decrypted with the key matching its header
+// so files written before and after a key was rotated can be read by passing both keys.
+func NewReader(r io.Reader, keys ...[]byte) (*Reader, error) {
+
+	cr := &Reader{
+		r:    bufio.NewReader(r),
+		keys: make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		cr.keys[KeyID(key)] = aead
+	}
+
+	return cr, nil
+}
+
+// Read reads decrypted entries. It returns io.EOF at the end of the file, ErrTruncated if the file ends
+// part way through a frame, and a *FrameError if a frame was changed or needs another key.
+func (r *Reader) Read(p []byte) (int, error) {
+
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.plain, r.err = r.next()
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads frames until one has data
+func (r *Reader) next() ([]byte, error) {
+
+	offset := r.offset
+
+	p := make([]byte, prefixSize)
+	if n, err := io.ReadFull(r.r, p); err != nil {
+		if n == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrTruncated
+	}
+
+	typ, size := p[0], binary.BigEndian.Uint32(p[1:])
+	if offset == 0 && typ != frameHeader {
+		return nil, ErrNotEncrypted
+	}
+	if size > maxPayload || (typ != frameHeader && typ != frameData) {
+		return nil, &FrameError{Offset: offset, Err: errors.New("not a frame, the file is damaged")}
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return nil, ErrTruncated
+	}
+	r.offset += prefixSize + int64(size)
+
+	if typ == frameHeader {
+		if len(payload) < 1 || payload[0] != version {
+			return nil, &FrameError{Offset: offset, Err: errors.New("unsupported version")}
+		}
+		id := hex.EncodeToString(payload[1:])
+		if r.aead = r.keys[id]; r.aead == nil {
+			return nil, &FrameError{Offset: offset, Err: fmt.Errorf("encrypted with key %s which was not given", id)}
+		}
+		return nil, nil
+	}
+
+	ns := r.aead.NonceSize()
+	if len(payload) < ns {
+		return nil, &FrameError{Offset: offset, Err: errors.New("frame is too short")}
+	}
+
+	plain, err := r.aead.Open(nil, payload[:ns], payload[ns:], p)
+	if err != nil {
+		return nil, &FrameError{Offset: offset, Err: errors.New("decryption failed, the frame was changed")}
+	}
+	return plain, nil
+}
@@ -1,14 +1,31 @@
 package logger
 
 import (
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/realugbun/logger/logcrypt"
 )
 
 const (
 	defaultLevel = logrus.InfoLevel
+
+	// defaultDepth is the number of frames read from the call stack when StackTrace.Depth is not set
+	defaultDepth = 100
+
+	// stackPageSize is the number of program counters read from the call stack at a time
+	stackPageSize = 15
+
+	// lambdaStopFunction is the function AWS uses to invoke Lambda handlers
+	lambdaStopFunction = "github.com/aws/aws-lambda-go/lambda.NewHandler"
+
+	// maxGoroutineDump limits the size of the goroutine stacks added to Panic and Fatal messages
+	maxGoroutineDump = 8 << 20
 )
 
 type (
@@ -19,40 +36,153 @@ type (
 )
 
 var (
-	options *Options
+	// current holds the *Options in use. It is swapped atomically so the logger can be
+	// reconfigured while other goroutines are logging.
+	current atomic.Value
+
+	// initMu serializes Init and InitWithOptions and guards output
+	initMu sync.Mutex
+
+	// output is the file opened by InitWithOptions. It is closed when the logger is reconfigured.
+	output io.Closer
+
+	// sinks are the sinks in use. They are closed when a later init removes them.
+	sinks []io.WriteCloser
+
+	// redirected is set when init changed the logrus output so the next init can restore standard error
+	redirected bool
+
+	// setFormatter sets the logrus formatter on the first init. logrus reads its formatter without a lock
+	// so it is never changed again. The layout of later inits is read from the options in use instead.
+	setFormatter sync.Once
+
+	// flightRecorder holds the *FlightRecorder used by entries without their own. It is nil when disabled.
+	flightRecorder atomic.Value
 )
 
+// getOptions returns the options in use or empty options if the logger has not been initialized
+func getOptions() *Options {
+	if o, ok := current.Load().(*Options); ok {
+		return o
+	}
+	return NewOptions()
+}
+
 // Init sets up the logger with default options: Log level info, IncludeFunc true, and output to standard output.
 // For more options use, InitWithOptions
+// It is safe to call while other goroutines are logging. Any file opened by a previous init is closed.
 func Init() {
 
-	logrus.SetFormatter(&logrus.JSONFormatter{})
+	initMu.Lock()
+	defer initMu.Unlock()
+
+	// Defaults to including the function info in the log
+	o := NewOptions().SetIncludeFunc(true)
+
+	o.formatter = newFormatter(o)
+	setFormatter.Do(func() { logrus.SetFormatter(optionsFormatter{}) })
 
 	// Use the default log level
 	SetLevel(defaultLevel.String())
 
-	// Defaults to including the function info in the log
-	o := NewOptions().SetIncludeFunc(true)
-	options = o
+	current.Store(o)
+	flightRecorder.Store((*FlightRecorder)(nil))
+
+	setOutput(nil, nil)
+	setSinks(nil)
 
 }
 
-// InitWithOptions inits the logger using the passed options
+// InitWithOptions inits the logger using the passed options.
+// The options are copied so changing them afterwards has no effect until InitWithOptions is called again.
+// It is safe to call while other goroutines are logging. Messages logged during the call use either
+// the previous or the new settings, and any file opened by a previous init is closed once
+// no more messages can be written to it.
+// If the file cannot be opened messages go to the fallback output. An invalid level uses the default level.
+// Use InitWithOptionsE to get these errors.
 func InitWithOptions(o *Options) {
+	initWithOptions(o, false)
+}
+
+// InitWithOptionsE validates the options then inits the logger like InitWithOptions.
+// Invalid options are returned as a *LevelError or *OptionError without changing the logger.
+// If the file cannot be opened a *FileError is returned. The logger is left unchanged
+// unless a fallback output is set, in which case it is initialized to write to the fallback.
+func InitWithOptionsE(o *Options) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	return initWithOptions(o, true)
+}
+
+// initWithOptions applies the options. When strict is set a file which cannot be opened
+// is returned as an error instead of being logged.
+func initWithOptions(o *Options, strict bool) error {
 
-	logrus.SetFormatter(&logrus.JSONFormatter{})
+	initMu.Lock()
+	defer initMu.Unlock()
 
-	options = o
+	o = o.clone()
 
 	// If a file location is passed, logging will be made to the file. Otherwise it goes to standard output.
+	var (
+		out     io.Writer
+		file    io.Closer
+		fileErr error
+	)
 	if o.File != nil {
-		f, err := os.OpenFile(o.GetFile(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		f, w, err := openFile(o)
 		if err != nil {
-			logrus.Warn("unable to open file", err)
+			fileErr = &FileError{File: o.GetFile(), Err: err}
+			if strict && o.FallbackOutput == nil {
+				return fileErr
+			}
+			if !strict {
+				logrus.Warn("unable to open file", err)
+			}
+			out = o.GetFallbackOutput()
+		} else {
+			out, file = w, f
 		}
-		logrus.SetOutput(f)
 	}
 
+	if o.Audit != nil {
+
+		// An encrypted file cannot be read to continue the chain
+		var f *os.File
+		if o.EncryptionKey == nil {
+			f, _ = file.(*os.File)
+		}
+		base := out
+		if base == nil {
+			base = os.Stderr
+		}
+		aw, err := newAuditWriter(base, f, o.Audit)
+		if err != nil {
+			if strict {
+				if f != nil {
+					f.Close()
+				}
+				return &FileError{File: o.GetFile(), Err: err}
+			}
+			logrus.Warn("unable to continue the audit chain, starting a new one ", err)
+		}
+		out, file = aw, aw
+	}
+
+	if len(o.Sinks) > 0 {
+		if out == nil {
+			out = os.Stderr
+		}
+		out = &teeWriter{out: out, sinks: o.Sinks}
+	}
+
+	o.formatter = newFormatter(o)
+	setFormatter.Do(func() { logrus.SetFormatter(optionsFormatter{}) })
+
+	setOutput(out, file)
+	setSinks(o.Sinks)
+
 	// Set the log level based on options
 	if o.Level == nil {
 		o.SetLevel(defaultLevel.String())
@@ -61,12 +191,93 @@ func InitWithOptions(o *Options) {
 
 	SetLevel(o.GetLevel())
 
-	if options.StackTrace != nil {
-		if options.StackTrace.GetLambda() {
-			options.StackTrace.SetStopFunction("github.com/aws/aws-lambda-go/lambda.NewHandler")
+	if o.StackTrace != nil {
+		if o.StackTrace.GetLambda() {
+			o.StackTrace.SetStopFunction(lambdaStopFunction)
 		}
+		if o.GetECS() && o.StackTrace.Format == nil {
+			o.StackTrace.SetFormat(TraceFormatPanic)
+		}
+	}
+
+	current.Store(o)
+	flightRecorder.Store(NewFlightRecorder(o.GetFlightRecorder()))
+
+	return fileErr
+}
+
+// openFile opens the log file for appending. The writer encrypts the file when EncryptionKey is set.
+func openFile(o *Options) (*os.File, io.Writer, error) {
+
+	f, err := os.OpenFile(o.GetFile(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil || o.EncryptionKey == nil {
+		return f, f, err
 	}
 
+	w, err := encryptFile(f, o.EncryptionKey)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, w, nil
+}
+
+// encryptFile gets the key then starts a new run of frames after any frame cut off by a crash
+func encryptFile(f *os.File, source logcrypt.KeySource) (io.Writer, error) {
+
+	key, err := source()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := logcrypt.TrimPartial(f); err != nil {
+		return nil, err
+	}
+
+	return logcrypt.NewWriter(f, key)
+}
+
+// setOutput sends messages to out, or to standard error if out is nil, then closes the previous file.
+// file is closed the next time the output changes.
+// logrus holds its lock while writing and while changing the output so nothing
+// is written to the previous file once SetOutput returns.
+func setOutput(out io.Writer, file io.Closer) {
+
+	if out != nil {
+		logrus.SetOutput(out)
+	} else if redirected {
+		logrus.SetOutput(os.Stderr)
+	}
+	redirected = out != nil
+
+	if output != nil {
+		output.Close()
+	}
+	output = file
+}
+
+// Close closes the file opened by InitWithOptions and the sinks, writing anything held back such as the final
+// audit signature or the last batch of a sink.
+// Call it before the program exits. Messages go to standard error until the logger is initialized again.
+func Close() error {
+
+	initMu.Lock()
+	defer initMu.Unlock()
+
+	if redirected {
+		logrus.SetOutput(os.Stderr)
+	}
+	redirected = false
+
+	err := setSinks(nil)
+
+	if output != nil {
+		if cerr := output.Close(); err == nil {
+			err = cerr
+		}
+		output = nil
+	}
+	return err
 }
 
 // SetLevel sets the logging level
@@ -83,56 +294,47 @@ func SetLevel(level string) {
 
 // Trace logs a message at level Trace on the standard logger.
 func Trace(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Trace(args...)
+	std.Trace(args...)
 }
 
 // Debug logs a message at level Debug on the standard logger.
 func Debug(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Debug(args...)
+	std.Debug(args...)
 }
 
 // Print logs a message at level Info on the standard logger.
 func Print(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Print(args...)
+	std.Print(args...)
 }
 
 // Info logs a message at level Info on the standard logger.
 func Info(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Info(args...)
+	std.Info(args...)
 }
 
 // Warn logs a message at level Warn on the standard logger.
 func Warn(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Warn(args...)
+	std.Warn(args...)
 }
 
 // Warning logs a message at level Warn on the standard logger.
 func Warning(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Warning(args...)
+	std.Warning(args...)
 }
 
 // Error logs a message at level Error on the standard logger.
 func Error(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Error(args...)
+	std.Error(args...)
 }
 
 // Panic logs a message at level Panic on the standard logger.
 func Panic(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Panic(args...)
+	std.Panic(args...)
 }
 
 // Fatal logs a message at level Fatal on the standard logger then the process will exit with status set to 1.
 func Fatal(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Fatal(args...)
+	std.Fatal(args...)
 }
 
 // TraceFn logs a message from a func at level Trace on the standard logger.
@@ -182,281 +384,225 @@ func FatalFn(fn LogFunction) {
 
 // Tracef logs a message at level Trace on the standard logger.
 func Tracef(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Tracef(format, args...)
+	std.Tracef(format, args...)
 }
 
 // Debugf logs a message at level Debug on the standard logger.
 func Debugf(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Debugf(format, args...)
+	std.Debugf(format, args...)
 }
 
 // Printf logs a message at level Info on the standard logger.
 func Printf(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Printf(format, args...)
+	std.Printf(format, args...)
 }
 
 // Infof logs a message at level Info on the standard logger.
 func Infof(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Infof(format, args...)
+	std.Infof(format, args...)
 }
 
 // Warnf logs a message at level Warn on the standard logger.
 func Warnf(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Warnf(format, args...)
+	std.Warnf(format, args...)
 }
 
 // Warningf logs a message at level Warn on the standard logger.
 func Warningf(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Warningf(format, args...)
+	std.Warningf(format, args...)
 }
 
 // Errorf logs a message at level Error on the standard logger.
 func Errorf(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Errorf(format, args...)
+	std.Errorf(format, args...)
 }
 
 // Panicf logs a message at level Panic on the standard logger.
 func Panicf(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Panicf(format, args...)
+	std.Panicf(format, args...)
 }
 
 // Fatalf logs a message at level Fatal on the standard logger then the process will exit with status set to 1.
 func Fatalf(format string, args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Fatalf(format, args...)
+	std.Fatalf(format, args...)
 }
 
 // Traceln logs a message at level Trace on the standard logger.
 func Traceln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Traceln(args...)
+	std.Traceln(args...)
 }
 
 // Debugln logs a message at level Debug on the standard logger.
 func Debugln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Debugln(args...)
+	std.Debugln(args...)
 }
 
 // Println logs a message at level Info on the standard logger.
 func Println(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Println(args...)
+	std.Println(args...)
 }
 
 // Infoln logs a message at level Info on the standard logger.
 func Infoln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Infoln(args...)
+	std.Infoln(args...)
 }
 
 // Warnln logs a message at level Warn on the standard logger.
 func Warnln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Warnln(args...)
+	std.Warnln(args...)
 }
 
 // Warningln logs a message at level Warn on the standard logger.
 func Warningln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Warningln(args...)
+	std.Warningln(args...)
 }
 
 // Errorln logs a message at level Error on the standard logger.
 func Errorln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Errorln(args...)
+	std.Errorln(args...)
 }
 
 // Panicln logs a message at level Panic on the standard logger.
 func Panicln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Panicln(args...)
+	std.Panicln(args...)
 }
 
 // Fatalln logs a message at level Fatal on the standard logger then the process will exit with status set to 1.
 func Fatalln(args ...interface{}) {
-	f := stackTrace()
-	logrus.WithFields(f).Fatalln(args...)
-}
-
-func (f *Fields) addFields(fields Fields) Fields {
-
-	ff := *f
-
-	for k, v := range fields {
-		ff[k] = v
-	}
-	f = &ff
-	return *f
+	std.Fatalln(args...)
 }
 
 // TraceWithFields logs a message with custom fields at level Trace on the standard logger.
 func TraceWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Trace(args...)
+	std.WithFields(fields).Trace(args...)
 }
 
 // DebugWithFields logs a message with custom fields at level Debug on the standard logger.
 func DebugWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Debug(args...)
+	std.WithFields(fields).Debug(args...)
 }
 
 // PrintWithFields logs a message with custom fields at level Info on the standard logger.
 func PrintWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Print(args...)
+	std.WithFields(fields).Print(args...)
 }
 
 // InfoWithFields logs a message with custom fields at level Info on the standard logger.
 func InfoWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Info(args...)
+	std.WithFields(fields).Info(args...)
 }
 
 // WarnWithFields logs a message with custom fields at level Warn on the standard logger.
 func WarnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Warn(args...)
+	std.WithFields(fields).Warn(args...)
 }
 
 // WarningWithFields logs a message with custom fields at level Warn on the standard logger.
 func WarningWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Warning(args...)
+	std.WithFields(fields).Warning(args...)
 }
 
 // ErrorWithFields logs a message with custom fields at level Error on the standard logger.
 func ErrorWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Error(args...)
+	std.WithFields(fields).Error(args...)
 }
 
 // PanicWithFields logs a message with custom fields at level Panic on the standard logger.
 func PanicWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Panic(args...)
+	std.WithFields(fields).Panic(args...)
 }
 
 // FatalWithFields logs a message with custom fields at level Fatal on the standard logger then the process will exit with status set to 1.
 func FatalWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Fatal(args...)
+	std.WithFields(fields).Fatal(args...)
 }
 
 // TracefWithFields logs a message with custom fields at level Trace on the standard logger.
 func TracefWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Tracef(format, args...)
+	std.WithFields(fields).Tracef(format, args...)
 }
 
 // DebugfWithFields logs a message with custom fields at level Debug on the standard logger.
 func DebugfWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Debugf(format, args...)
+	std.WithFields(fields).Debugf(format, args...)
 }
 
 // PrintfWithFields logs a message with custom fields at level Info on the standard logger.
 func PrintfWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Printf(format, args...)
+	std.WithFields(fields).Printf(format, args...)
 }
 
 // InfofWithFields logs a message with custom fields at level Info on the standard logger.
 func InfofWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Infof(format, args...)
+	std.WithFields(fields).Infof(format, args...)
 }
 
 // WarnfWithFields logs a message with custom fields at level Warn on the standard logger.
 func WarnfWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Warnf(format, args...)
+	std.WithFields(fields).Warnf(format, args...)
 }
 
 // WarningfWithFields logs a message with custom fields at level Warn on the standard logger.
 func WarningfWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Warningf(format, args...)
+	std.WithFields(fields).Warningf(format, args...)
 }
 
 // ErrorfWithFields logs a message with custom fields at level Error on the standard logger.
 func ErrorfWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Errorf(format, args...)
+	std.WithFields(fields).Errorf(format, args...)
 }
 
 // PanicfWithFields logs a message with custom fields at level Panic on the standard logger.
 func PanicfWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Panicf(format, args...)
+	std.WithFields(fields).Panicf(format, args...)
 }
 
 // FatalfWithFields logs a message with custom fields at level Fatal on the standard logger then the process will exit with status set to 1.
 func FatalfWithFields(fields Fields, format string, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Fatalf(format, args...)
+	std.WithFields(fields).Fatalf(format, args...)
 }
 
 // TracelnWithFields logs a message with custom fields at level Trace on the standard logger.
 func TracelnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Traceln(args...)
+	std.WithFields(fields).Traceln(args...)
 }
 
 // DebuglnWithFields logs a message with custom fields at level Debug on the standard logger.
 func DebuglnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Debugln(args...)
+	std.WithFields(fields).Debugln(args...)
 }
 
 // PrintlnWithFields logs a message with custom fields at level Info on the standard logger.
 func PrintlnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Println(args...)
+	std.WithFields(fields).Println(args...)
 }
 
 // InfolnWithFields logs a message with custom fields at level Info on the standard logger.
 func InfolnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Infoln(args...)
+	std.WithFields(fields).Infoln(args...)
 }
 
 // WarnlnWithFields logs a message with custom fields at level Warn on the standard logger.
 func WarnlnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Warnln(args...)
+	std.WithFields(fields).Warnln(args...)
 }
 
 // WarninglnWithFields logs a message with custom fields at level Warn on the standard logger.
 func WarninglnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Warningln(args...)
+	std.WithFields(fields).Warningln(args...)
 }
 
 // ErrorlnWithFields logs a message with custom fields at level Error on the standard logger.
 func ErrorlnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Errorln(args...)
+	std.WithFields(fields).Errorln(args...)
 }
 
 // PaniclnWithFields logs a message with custom fields at level Panic on the standard logger.
 func PaniclnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Panicln(args...)
+	std.WithFields(fields).Panicln(args...)
 }
 
 // FatallnWithFields logs a message with custom fields at level Fatal on the standard logger then the process will exit with status set to 1.
 func FatallnWithFields(fields Fields, args ...interface{}) {
-	fields.addFields(Fields(stackTrace()))
-	logrus.WithFields(logrus.Fields(fields)).Fatalln(args...)
+	std.WithFields(fields).Fatalln(args...)
 }
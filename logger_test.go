@@ -1,11 +1,26 @@
 package logger
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/realugbun/logger/internal/audit"
+	"github.com/realugbun/logger/logcrypt"
 )
 
 func Test_InitWithOptions(t *testing.T) {
@@ -78,7 +93,7 @@ func Test_stackTrace(t *testing.T) {
 			}
 
 			InitWithOptions(options)
-			actOut := stackTrace()
+			actOut := stackTrace(getOptions(), logrus.InfoLevel, options.StackTrace)
 
 			_, isTrace := actOut["trace"]
 			function := actOut["func"]
@@ -95,3 +110,1221 @@ func Test_stackTrace(t *testing.T) {
 		})
 	}
 }
+
+func Test_formatPath(t *testing.T) {
+
+	const (
+		file     = "/Users/realugbun/go/src/github.com/realugbun/logger/options.go"
+		function = "github.com/realugbun/logger.(*Options).SetFile"
+	)
+
+	for _, tc := range []struct {
+		name     string
+		format   string
+		prefix   string
+		file     string
+		function string
+		expPath  string
+	}{
+		{
+			name:     "full",
+			file:     file,
+			function: function,
+			expPath:  file,
+		},
+		{
+			name:     "trim prefix",
+			prefix:   "/Users/realugbun/go/src/",
+			file:     file,
+			function: function,
+			expPath:  "github.com/realugbun/logger/options.go",
+		},
+		{
+			name:     "short",
+			format:   FilePathShort,
+			file:     file,
+			function: function,
+			expPath:  "logger/options.go",
+		},
+		{
+			name:     "module",
+			format:   FilePathModule,
+			file:     file,
+			function: function,
+			expPath:  "options.go",
+		},
+		{
+			name:     "module dependency",
+			format:   FilePathModule,
+			file:     "/root/go/pkg/mod/github.com/sirupsen/logrus@v1.8.1/entry.go",
+			function: "github.com/sirupsen/logrus.(*Entry).log",
+			expPath:  "github.com/sirupsen/logrus/entry.go",
+		},
+		{
+			name:     "module standard library",
+			format:   FilePathModule,
+			file:     "/usr/local/go/src/runtime/proc.go",
+			function: "runtime.main",
+			expPath:  "runtime/proc.go",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			o := NewOptions()
+			if tc.format != "" {
+				o.SetFilePath(tc.format)
+			}
+			if tc.prefix != "" {
+				o.SetTrimPrefix(tc.prefix)
+			}
+
+			assert.Equal(t, tc.expPath, formatPath(o, tc.file, tc.function))
+		})
+	}
+}
+
+func Test_isGoRoot(t *testing.T) {
+
+	assert.True(t, isGoRoot("runtime.goexit"))
+	assert.True(t, isGoRoot("net/http.(*conn).serve"))
+	assert.False(t, isGoRoot("main.main"))
+	assert.False(t, isGoRoot("github.com/realugbun/logger.Info"))
+
+	// A module path without a dot is not the standard library
+	readBuildPaths()
+	defer func(m []string) { modules = m }(modules)
+	modules = append(modules[:len(modules):len(modules)], "myapp")
+
+	assert.False(t, isGoRoot("myapp.Run"))
+	assert.False(t, isGoRoot("myapp/handlers.(*Server).Get"))
+	assert.True(t, isGoRoot("runtime.main"))
+}
+
+func Test_packageName(t *testing.T) {
+
+	assert.Equal(t, "github.com/realugbun/logger", packageName("github.com/realugbun/logger.(*Options).SetFile"))
+	assert.Equal(t, "main", packageName("main.main.func1"))
+	assert.Equal(t, "gopkg.in/yaml.v3", packageName("gopkg.in/yaml%2ev3.Marshal"))
+	assert.Equal(t, "example.com/a.b/c.d", packageName("example.com/a.b/c%2ed.(*T).Run.func1"))
+	assert.Equal(t, "", packageName("example.com/nofunc"))
+
+	o := NewOptions().AddSkipPackages("gopkg.in/yaml.v3")
+	assert.True(t, isLoggerCall(o, runtime.Frame{Function: "gopkg.in/yaml%2ev3.Marshal"}))
+}
+
+func Test_stackTraceDepth(t *testing.T) {
+
+	// recurse builds a call stack deeper than a single page of program counters
+	var recurse func(n int, level logrus.Level) logrus.Fields
+	recurse = func(n int, level logrus.Level) logrus.Fields {
+		if n == 0 {
+			return stackTrace(getOptions(), level, getOptions().StackTrace)
+		}
+		return recurse(n-1, level)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		options       *StackTrace
+		level         logrus.Level
+		minEntries    int
+		maxEntries    int
+		expTruncated  bool
+		expGoroutines bool
+	}{
+		{
+			name:       "deep stack",
+			options:    NewStackTrace(),
+			level:      logrus.InfoLevel,
+			minEntries: 40,
+			maxEntries: 100,
+		},
+		{
+			name:         "max entries",
+			options:      NewStackTrace().SetMaxEntries(5),
+			level:        logrus.InfoLevel,
+			minEntries:   5,
+			maxEntries:   5,
+			expTruncated: true,
+		},
+		{
+			// recurse adds 40 frames after the caller then the test adds one more before the Go runtime
+			name:       "max entries without go root",
+			options:    NewStackTrace().SetMaxEntries(41).SetOmitGoRoot(true),
+			level:      logrus.InfoLevel,
+			minEntries: 41,
+			maxEntries: 41,
+		},
+		{
+			name:         "max entries before the last app frame",
+			options:      NewStackTrace().SetMaxEntries(40).SetOmitGoRoot(true),
+			level:        logrus.InfoLevel,
+			minEntries:   40,
+			maxEntries:   40,
+			expTruncated: true,
+		},
+		{
+			name:         "depth",
+			options:      NewStackTrace().SetDepth(20),
+			level:        logrus.InfoLevel,
+			minEntries:   1,
+			maxEntries:   20,
+			expTruncated: true,
+		},
+		{
+			name:          "all goroutines",
+			options:       NewStackTrace().SetMaxEntries(5).SetAllGoroutines(true),
+			level:         logrus.PanicLevel,
+			minEntries:    5,
+			maxEntries:    5,
+			expTruncated:  true,
+			expGoroutines: true,
+		},
+		{
+			name:          "all goroutines at fatal",
+			options:       NewStackTrace().SetMaxEntries(5).SetAllGoroutines(true),
+			level:         logrus.FatalLevel,
+			minEntries:    5,
+			maxEntries:    5,
+			expTruncated:  true,
+			expGoroutines: true,
+		},
+		{
+			name:         "all goroutines below fatal",
+			options:      NewStackTrace().SetMaxEntries(5).SetAllGoroutines(true),
+			level:        logrus.ErrorLevel,
+			minEntries:   5,
+			maxEntries:   5,
+			expTruncated: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			current.Store(NewOptions().SetIncludeFunc(true).SetStackTrace(*tc.options))
+
+			actOut := recurse(40, tc.level)
+
+			trace, _ := actOut["trace"].([]map[string]interface{})
+			assert.True(t, len(trace) >= tc.minEntries, "trace has %d entries", len(trace))
+			assert.True(t, len(trace) <= tc.maxEntries, "trace has %d entries", len(trace))
+
+			_, isTruncated := actOut["trace_truncated"]
+			assert.Equal(t, tc.expTruncated, isTruncated)
+
+			goroutines, isGoroutines := actOut["goroutines"]
+			assert.Equal(t, tc.expGoroutines, isGoroutines)
+			if tc.expGoroutines {
+				assert.Contains(t, goroutines, "goroutine ")
+			}
+		})
+	}
+}
+
+// logEntry inits the logger with the options and returns the message logged by fn
+func logEntry(t *testing.T, o *Options, fn func()) map[string]interface{} {
+
+	entries := logEntries(t, o, fn)
+	if !assert.Len(t, entries, 1) {
+		return nil
+	}
+	return entries[0]
+}
+
+// logEntries inits the logger with the options and returns every message logged by fn
+func logEntries(t *testing.T, o *Options, fn func()) []map[string]interface{} {
+
+	var buf bytes.Buffer
+	InitWithOptions(o)
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	fn()
+
+	var entries []map[string]interface{}
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal(s.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// logHelper wraps the logger the way a helper function in an application would
+func logHelper(msg string) {
+	Info(msg)
+}
+
+func Test_callerSkip(t *testing.T) {
+
+	for _, tc := range []struct {
+		name    string
+		skip    int
+		log     func()
+		expFunc string
+	}{
+		{
+			name:    "direct call",
+			log:     func() { Info("direct") },
+			expFunc: "logger.Test_callerSkip.func1",
+		},
+		{
+			name:    "helper without skip",
+			log:     func() { logHelper("helper") },
+			expFunc: "logger.logHelper",
+		},
+		{
+			name:    "helper with skip",
+			skip:    1,
+			log:     func() { logHelper("helper") },
+			expFunc: "logger.Test_callerSkip.func3",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			entry := logEntry(t, NewOptions().SetIncludeFunc(true).AddCallerSkip(tc.skip), tc.log)
+
+			assert.Equal(t, tc.expFunc, entry["func"])
+			assert.True(t, strings.HasSuffix(entry["file"].(string), "logger_test.go"))
+		})
+	}
+}
+
+func Test_isLoggerCall(t *testing.T) {
+
+	o := NewOptions().AddSkipPackages("github.com/realugbun/loggerutil")
+
+	for _, tc := range []struct {
+		name    string
+		frame   runtime.Frame
+		expSkip bool
+	}{
+		{
+			name:    "logger",
+			frame:   runtime.Frame{Function: "github.com/realugbun/logger.Info", File: "/src/logger/logger.go"},
+			expSkip: true,
+		},
+		{
+			name:    "logger tests",
+			frame:   runtime.Frame{Function: "github.com/realugbun/logger.Test_isLoggerCall", File: "/src/logger/logger_test.go"},
+			expSkip: false,
+		},
+		{
+			name:    "skipped package",
+			frame:   runtime.Frame{Function: "github.com/realugbun/loggerutil.Info", File: "/src/loggerutil/util.go"},
+			expSkip: true,
+		},
+		{
+			name:    "similar directory",
+			frame:   runtime.Frame{Function: "github.com/realugbun/loggerutils.Info", File: "/src/loggerutils/util.go"},
+			expSkip: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expSkip, isLoggerCall(o, tc.frame))
+		})
+	}
+}
+
+func Test_stackTraceLevels(t *testing.T) {
+
+	errorTraces := NewOptions().SetIncludeFunc(true).SetStackTrace(*NewStackTrace().SetMinLevel("error"))
+
+	for _, tc := range []struct {
+		name     string
+		options  *Options
+		log      func()
+		expFunc  bool
+		expTrace bool
+	}{
+		{
+			name:     "below min level",
+			options:  errorTraces,
+			log:      func() { Info("info") },
+			expFunc:  true,
+			expTrace: false,
+		},
+		{
+			name:     "at min level",
+			options:  errorTraces,
+			log:      func() { Error("error") },
+			expFunc:  true,
+			expTrace: true,
+		},
+		{
+			name:     "with stack",
+			options:  errorTraces,
+			log:      func() { WithStack().Info("info") },
+			expFunc:  true,
+			expTrace: true,
+		},
+		{
+			name:     "without stack",
+			options:  errorTraces,
+			log:      func() { WithoutStack().Errorf("error %d", 1) },
+			expFunc:  true,
+			expTrace: false,
+		},
+		{
+			name:     "with stack without options",
+			options:  NewOptions(),
+			log:      func() { WithStack().WithFields(Fields{"key": "value"}).Warnln("warn") },
+			expFunc:  true,
+			expTrace: true,
+		},
+		{
+			name:     "without include func",
+			options:  NewOptions(),
+			log:      func() { Error("error") },
+			expFunc:  false,
+			expTrace: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			entry := logEntry(t, tc.options, tc.log)
+
+			_, isFunc := entry["func"]
+			_, isTrace := entry["trace"]
+			assert.Equal(t, tc.expFunc, isFunc)
+			assert.Equal(t, tc.expTrace, isTrace)
+		})
+	}
+}
+
+func Test_WithFields(t *testing.T) {
+
+	fields := Fields{"request_id": "b7ad6b71", "method": "/users.Users/Get"}
+	base := WithStack().WithFields(fields)
+	chained := base.WithFields(Fields{"method": "/users.Users/List", "user": 1})
+
+	// Changing the map after it was passed does not change the entry
+	fields["request_id"] = "changed"
+
+	entry := logEntry(t, NewOptions(), func() { chained.Info("listing users") })
+
+	// Chained fields are added to the entry's fields and keep its stack override
+	assert.Equal(t, "b7ad6b71", entry["request_id"])
+	assert.Equal(t, "/users.Users/List", entry["method"])
+	assert.Equal(t, float64(1), entry["user"])
+	assert.Contains(t, entry, "trace")
+	assert.Equal(t, Fields{"request_id": "b7ad6b71", "method": "/users.Users/Get"}, base.fields)
+}
+
+func Test_renderTrace(t *testing.T) {
+
+	o := NewOptions()
+	trace := []runtime.Frame{
+		{Function: "main.main", File: "/app/main.go", Line: 12},
+		{Function: "runtime.main", File: "/usr/local/go/src/runtime/proc.go", Line: 255},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		format   string
+		expTrace interface{}
+	}{
+		{
+			name:   "fields",
+			format: TraceFormatFields,
+			expTrace: []map[string]interface{}{
+				{"file": "/app/main.go", "line": 12, "function": "main.main"},
+				{"file": "/usr/local/go/src/runtime/proc.go", "line": 255, "function": "runtime.main"},
+			},
+		},
+		{
+			name:   "compact",
+			format: TraceFormatCompact,
+			expTrace: []string{
+				"main.main@/app/main.go:12",
+				"runtime.main@/usr/local/go/src/runtime/proc.go:255",
+			},
+		},
+		{
+			name:     "panic",
+			format:   TraceFormatPanic,
+			expTrace: "\nmain.main(...)\n\t/app/main.go:12\nruntime.main(...)\n\t/usr/local/go/src/runtime/proc.go:255",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			// The panic format starts with the id of the goroutine running the test
+			expTrace := tc.expTrace
+			if s, ok := expTrace.(string); ok {
+				expTrace = "goroutine " + goroutineID() + " [running]:" + s
+			}
+
+			assert.Equal(t, expTrace, renderTrace(o, trace, tc.format))
+		})
+	}
+}
+
+func Test_mergeFields(t *testing.T) {
+
+	caller := logrus.Fields{"file": "main.go", "line": 12, "func": "main.main"}
+
+	for _, tc := range []struct {
+		name      string
+		collision string
+		fields    Fields
+		expFields logrus.Fields
+	}{
+		{
+			name:      "prefix",
+			fields:    Fields{"file": "upload.csv", "id": 1},
+			expFields: logrus.Fields{"fields.file": "upload.csv", "id": 1, "file": "main.go", "line": 12, "func": "main.main"},
+		},
+		{
+			name:      "nest",
+			collision: FieldCollisionNest,
+			fields:    Fields{"file": "upload.csv", "caller": "api"},
+			expFields: logrus.Fields{"file": "upload.csv", "fields.caller": "api", "caller": caller},
+		},
+		{
+			name:      "no custom fields",
+			expFields: caller,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			o := NewOptions()
+			if tc.collision != "" {
+				o.SetFieldCollision(tc.collision)
+			}
+
+			fields, expCustom := Fields{}, Fields{}
+			for k, v := range tc.fields {
+				fields[k] = v
+				expCustom[k] = v
+			}
+
+			assert.Equal(t, tc.expFields, mergeFields(o, fields, caller))
+			assert.Equal(t, expCustom, fields) // Custom fields are not modified
+		})
+	}
+}
+
+func Test_reuseFields(t *testing.T) {
+
+	InitWithOptions(NewOptions().SetIncludeFunc(true).SetLevel("error"))
+
+	// The same map is shared between goroutines which is reported by go test -race if it is written to
+	fields := Fields{"file": "upload.csv"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			WarnWithFields(fields, "below level")
+			ErrorfWithFields(fields, "upload %s failed", "upload.csv")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, Fields{"file": "upload.csv"}, fields)
+}
+
+func Test_reconfigure(t *testing.T) {
+
+	files := []string{"./Test_reconfigure_1.log", "./Test_reconfigure_2.log"}
+	for _, f := range files {
+		defer os.Remove(f)
+	}
+
+	InitWithOptions(NewOptions().SetFile(files[0]))
+	first := output.(*os.File)
+
+	// Log from other goroutines while the logger is reconfigured which is reported by go test -race if unsafe
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					WithStack().WithFields(Fields{"id": 1}).Info("reconfigure")
+				}
+			}
+		}()
+	}
+
+	// Reconfigure from several goroutines at once with layouts that change the formatter
+	var inits sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		inits.Add(1)
+		go func(i int) {
+			defer inits.Done()
+			for j := 0; j < 25; j++ {
+				o := NewOptions().SetFile(files[j%2]).SetIncludeFunc(j%2 == 0)
+				if i%2 == 0 {
+					o.SetTimeFormat(TimeFormatUnixNanos).SetFieldMap(FieldMap{FieldKeyMsg: "message"})
+				}
+				InitWithOptions(o)
+			}
+		}(i)
+	}
+	inits.Wait()
+	close(done)
+	wg.Wait()
+
+	// The first file was closed when the logger was reconfigured
+	_, err := first.Write([]byte("closed"))
+	assert.Error(t, err)
+
+	// Init closes the last file and goes back to standard error
+	last := output.(*os.File)
+	Init()
+	assert.Nil(t, output)
+	_, err = last.Write([]byte("closed"))
+	assert.Error(t, err)
+}
+
+func Test_Validate(t *testing.T) {
+
+	for _, tc := range []struct {
+		name      string
+		options   *Options
+		expOption string
+	}{
+		{
+			name:    "valid",
+			options: NewOptions().SetLevel("debug").SetIncludeFunc(true).SetStackTrace(*NewStackTrace().SetMinLevel("error")),
+		},
+		{
+			name:      "invalid level",
+			options:   NewOptions().SetLevel("loud"),
+			expOption: "Level",
+		},
+		{
+			name:      "invalid file path",
+			options:   NewOptions().SetFilePath("relative"),
+			expOption: "FilePath",
+		},
+		{
+			name:      "negative flight recorder",
+			options:   NewOptions().SetFlightRecorder(-1),
+			expOption: "FlightRecorder",
+		},
+		{
+			name:      "audit segment size",
+			options:   NewOptions().SetAudit(*NewAudit().SetSegmentSize(0)),
+			expOption: "Audit.SegmentSize",
+		},
+		{
+			name:      "audit signing key",
+			options:   NewOptions().SetAudit(*NewAudit().SetSigningKey(make(ed25519.PrivateKey, 10))),
+			expOption: "Audit.SigningKey",
+		},
+		{
+			name:      "encryption without file",
+			options:   NewOptions().SetEncryptionKey(logcrypt.StaticKey(make([]byte, 32))),
+			expOption: "EncryptionKey",
+		},
+		{
+			name:      "encryption with audit",
+			options:   NewOptions().SetFile("app.log").SetAudit(*NewAudit()).SetEncryptionKey(logcrypt.StaticKey(make([]byte, 32))),
+			expOption: "EncryptionKey",
+		},
+		{
+			name:      "ecs with nested caller fields",
+			options:   NewOptions().SetECS(true).SetFieldCollision(FieldCollisionNest),
+			expOption: "ECS",
+		},
+		{
+			name:      "ecs with the fields trace format",
+			options:   NewOptions().SetIncludeFunc(true).SetECS(true).SetStackTrace(*NewStackTrace().SetFormat(TraceFormatFields)),
+			expOption: "ECS",
+		},
+		{
+			name:      "stack trace without include func",
+			options:   NewOptions().SetStackTrace(*NewStackTrace()),
+			expOption: "StackTrace",
+		},
+		{
+			name:      "invalid stack trace min level",
+			options:   NewOptions().SetIncludeFunc(true).SetStackTrace(*NewStackTrace().SetMinLevel("loud")),
+			expOption: "StackTrace.MinLevel",
+		},
+		{
+			name:      "max entries more than depth",
+			options:   NewOptions().SetIncludeFunc(true).SetStackTrace(*NewStackTrace().SetDepth(10).SetMaxEntries(20)),
+			expOption: "StackTrace.MaxEntries",
+		},
+		{
+			name:      "lambda with stop function",
+			options:   NewOptions().SetIncludeFunc(true).SetStackTrace(*NewStackTrace().SetLambda(true).SetStopFunction("main.main")),
+			expOption: "StackTrace.Lambda",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			err := tc.options.Validate()
+			if tc.expOption == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			switch e := err.(type) {
+			case *LevelError:
+				assert.Equal(t, tc.expOption, e.Option)
+			case *OptionError:
+				assert.Equal(t, tc.expOption, e.Option)
+			default:
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func Test_InitWithOptionsE(t *testing.T) {
+
+	const missingFile = "./missing/Test_InitWithOptionsE.log"
+
+	t.Run("invalid options", func(t *testing.T) {
+		Init()
+		before := getOptions()
+
+		err := InitWithOptionsE(NewOptions().SetLevel("loud"))
+		assert.IsType(t, &LevelError{}, err)
+		assert.True(t, before == getOptions())
+	})
+
+	t.Run("file without fallback", func(t *testing.T) {
+		Init()
+		before := getOptions()
+
+		err := InitWithOptionsE(NewOptions().SetFile(missingFile))
+		assert.IsType(t, &FileError{}, err)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+		assert.True(t, before == getOptions())
+	})
+
+	t.Run("file with fallback", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		err := InitWithOptionsE(NewOptions().SetFile(missingFile).SetFallbackOutput(&buf))
+		assert.IsType(t, &FileError{}, err)
+
+		Info("fallback")
+		assert.Contains(t, buf.String(), "fallback")
+
+		// Reconfiguring without a file goes back to standard error
+		Init()
+		Info("standard error")
+		assert.NotContains(t, buf.String(), "standard error")
+	})
+}
+
+func Test_formatter(t *testing.T) {
+
+	schema := FieldMap{
+		FieldKeyTime:  "timestamp",
+		FieldKeyLevel: "severity",
+		FieldKeyMsg:   "message",
+		FieldKeyFile:  "logger.caller.file",
+		FieldKeyLine:  "logger.caller.line",
+		FieldKeyFunc:  "logger.caller.func",
+	}
+
+	for _, tc := range []struct {
+		name      string
+		options   *Options
+		expKeys   []string
+		expFields map[string]interface{}
+	}{
+		{
+			name:    "default names",
+			options: NewOptions().SetIncludeFunc(true),
+			expKeys: []string{"time", "level", "msg", "file", "line", "func", "id", "fields.file"},
+		},
+		{
+			name:    "field map",
+			options: NewOptions().SetIncludeFunc(true).SetFieldMap(schema),
+			expKeys: []string{"timestamp", "severity", "message", "logger", "id", "fields.file"},
+			expFields: map[string]interface{}{
+				"message":  "custom names",
+				"severity": "info",
+			},
+		},
+		{
+			name: "nested caller",
+			options: NewOptions().SetIncludeFunc(true).SetFieldCollision(FieldCollisionNest).
+				SetFieldMap(FieldMap{FieldKeyCaller: "logger.caller"}),
+			expKeys: []string{"time", "level", "msg", "logger", "id", "file"},
+		},
+		{
+			name:    "fields key",
+			options: NewOptions().SetIncludeFunc(true).SetFieldsKey("data"),
+			expKeys: []string{"time", "level", "msg", "file", "line", "func", "data"},
+			expFields: map[string]interface{}{
+				"data": map[string]interface{}{"id": float64(1), "fields.file": "upload.csv"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			entry := logEntry(t, tc.options, func() {
+				InfoWithFields(Fields{"id": 1, "file": "upload.csv"}, "custom names")
+			})
+
+			keys := make([]string, 0, len(entry))
+			for k := range entry {
+				keys = append(keys, k)
+			}
+			assert.ElementsMatch(t, tc.expKeys, keys)
+
+			for k, v := range tc.expFields {
+				assert.Equal(t, v, entry[k])
+			}
+
+			if logger, ok := entry["logger"].(map[string]interface{}); ok {
+				caller := logger["caller"].(map[string]interface{})
+				assert.Equal(t, "logger.Test_formatter.func1.1", caller["func"])
+			}
+		})
+	}
+}
+
+func Test_formatTime(t *testing.T) {
+
+	ts := time.Date(2022, 2, 6, 12, 50, 44, 123456789, time.FixedZone("EST", -5*60*60))
+
+	for _, tc := range []struct {
+		name    string
+		options *Options
+		expTime interface{}
+	}{
+		{
+			name:    "default",
+			options: NewOptions(),
+			expTime: "2022-02-06T12:50:44-05:00",
+		},
+		{
+			name:    "RFC3339 nano UTC",
+			options: NewOptions().SetTimeFormat(TimeFormatRFC3339Nano).SetUTC(true),
+			expTime: "2022-02-06T17:50:44.123456789Z",
+		},
+		{
+			name:    "unix millis",
+			options: NewOptions().SetTimeFormat(TimeFormatUnixMillis),
+			expTime: int64(1644169844123),
+		},
+		{
+			name:    "unix nanos",
+			options: NewOptions().SetTimeFormat(TimeFormatUnixNanos),
+			expTime: int64(1644169844123456789),
+		},
+		{
+			name:    "custom layout",
+			options: NewOptions().SetTimeFormat("2006-01-02 15:04:05.000").SetUTC(true),
+			expTime: "2022-02-06 17:50:44.123",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expTime, newFormatter(tc.options).formatTime(ts))
+		})
+	}
+}
+
+func Test_elapsed(t *testing.T) {
+
+	f := newFormatter(NewOptions().SetElapsed(true))
+
+	var elapsed []int64
+	for i := 0; i < 2; i++ {
+		out, err := f.Format(&logrus.Entry{Time: time.Now(), Level: logrus.InfoLevel, Message: "elapsed"})
+		assert.NoError(t, err)
+
+		var e struct{ Elapsed int64 }
+		assert.NoError(t, json.Unmarshal(out, &e))
+		elapsed = append(elapsed, e.Elapsed)
+	}
+
+	assert.True(t, elapsed[0] > 0)
+	assert.True(t, elapsed[1] >= elapsed[0])
+}
+
+func Test_metadata(t *testing.T) {
+
+	o := NewOptions().
+		SetServiceName("payments").
+		SetServiceVersion("1.2.3").
+		SetEnvironment("production").
+		SetIncludePID(true).
+		SetIncludeHostname(true).
+		SetIncludeBuildInfo(true).
+		SetStaticFields(Fields{"team": "billing", "region": "us-east-1", "pid": "static"})
+
+	entry := logEntry(t, o, func() {
+		InfoWithFields(Fields{"region": "eu-west-1"}, "metadata")
+	})
+
+	hostname, _ := os.Hostname()
+
+	assert.Equal(t, "payments", entry["service"])
+	assert.Equal(t, "1.2.3", entry["version"])
+	assert.Equal(t, "production", entry["env"])
+	assert.Equal(t, float64(os.Getpid()), entry["pid"])
+	assert.Equal(t, hostname, entry["hostname"])
+	assert.Equal(t, runtime.Version(), entry["go_version"])
+	assert.Equal(t, "billing", entry["team"])
+	assert.Equal(t, "eu-west-1", entry["region"])
+	assert.Equal(t, "static", entry["fields.pid"])
+}
+
+func Test_kubernetesFields(t *testing.T) {
+
+	for _, tc := range []struct {
+		name      string
+		root      string
+		env       map[string]string
+		expFields Fields
+	}{
+		{
+			name: "kubernetes",
+			root: "testdata/kubernetes",
+			env:  map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1", "POD_NAME": "payments-7d9c6b5f4-x2x8q", "NODE_NAME": "node-1"},
+			expFields: Fields{
+				FieldKeyPodName:     "payments-7d9c6b5f4-x2x8q",
+				FieldKeyNamespace:   "payments-prod",
+				FieldKeyNodeName:    "node-1",
+				FieldKeyContainerID: "3f5c1b8e9a7d6c4b2a0f1e3d5c7b9a8f6e4d2c0b1a3f5e7d9c8b6a4f2e0d1c3b",
+				FieldKeyLabels:      map[string]string{"app": "payments", "pod-template-hash": "7d9c6b5f4", "tier": `back"end`},
+			},
+		},
+		{
+			name: "namespace from environment",
+			root: "testdata/kubernetes",
+			env:  map[string]string{"POD_NAMESPACE": "payments-dev", "HOSTNAME": "payments-0"},
+			expFields: Fields{
+				FieldKeyPodName:     "payments-0",
+				FieldKeyNamespace:   "payments-dev",
+				FieldKeyContainerID: "3f5c1b8e9a7d6c4b2a0f1e3d5c7b9a8f6e4d2c0b1a3f5e7d9c8b6a4f2e0d1c3b",
+				FieldKeyLabels:      map[string]string{"app": "payments", "pod-template-hash": "7d9c6b5f4", "tier": `back"end`},
+			},
+		},
+		{
+			name: "docker with cgroup v2",
+			root: "testdata/docker",
+			expFields: Fields{
+				FieldKeyContainerID: "9b2e4f6a8c0d1e3f5a7b9c1d3e5f7a9b1c3d5e7f9a1b3c5d7e9f1a3b5c7d9e1f",
+			},
+		},
+		{
+			name:      "not in a container",
+			root:      "testdata/missing",
+			expFields: Fields{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			env := podEnv{
+				getenv:     func(name string) string { return tc.env[name] },
+				root:       tc.root,
+				podInfoDir: DefaultPodInfoDir,
+			}
+
+			assert.Equal(t, tc.expFields, env.kubernetesFields())
+		})
+	}
+}
+
+func Test_FromContext(t *testing.T) {
+
+	assert.Equal(t, std, FromContext(context.Background()))
+
+	base := WithFields(Fields{"request_id": "b7ad6b71", "method": "/users.Users/Get"})
+	ctx := NewContext(context.Background(), base)
+
+	entry := logEntry(t, NewOptions(), func() {
+		FromContext(ctx).WithFields(Fields{"method": "/users.Users/List", "user": 1}).Info("listing users")
+	})
+
+	// Fields are added to the entry's fields without modifying them
+	assert.Equal(t, "b7ad6b71", entry["request_id"])
+	assert.Equal(t, "/users.Users/List", entry["method"])
+	assert.Equal(t, float64(1), entry["user"])
+	assert.Equal(t, Fields{"request_id": "b7ad6b71", "method": "/users.Users/Get"}, base.fields)
+}
+
+func Test_flightRecorder(t *testing.T) {
+
+	entries := logEntries(t, NewOptions().SetIncludeFunc(true).SetLevel("info").SetFlightRecorder(2), func() {
+		Debug("opening file")
+		TraceWithFields(Fields{"file": "upload.csv", "err": errors.New("not found")}, "checking cache")
+		Debugf("retrying %d", 1)
+		Info("written")
+		Error("upload failed")
+		Error("upload failed again")
+	})
+
+	if !assert.Len(t, entries, 3) {
+		return
+	}
+
+	// Only the last two entries below the level are kept
+	recorded, ok := entries[1][FieldKeyFlightRecorder].([]interface{})
+	if assert.True(t, ok) && assert.Len(t, recorded, 2) {
+
+		first := recorded[0].(map[string]interface{})
+		assert.Equal(t, "checking cache", first["msg"])
+		assert.Equal(t, "trace", first["level"])
+		assert.Equal(t, "upload.csv", first["fields.file"])
+		assert.Equal(t, "not found", first["err"])
+		assert.Equal(t, "logger.Test_flightRecorder.func1", first["func"])
+		assert.NotEmpty(t, first["time"])
+
+		assert.Equal(t, "retrying 1", recorded[1].(map[string]interface{})["msg"])
+	}
+
+	// The recorder is cleared after each error
+	assert.NotContains(t, entries[0], FieldKeyFlightRecorder)
+	assert.NotContains(t, entries[2], FieldKeyFlightRecorder)
+}
+
+func Test_flightRecorderLayout(t *testing.T) {
+
+	o := NewOptions().SetIncludeFunc(true).SetLevel("info").SetFlightRecorder(2).SetECS(true).
+		SetFieldMap(FieldMap{FieldKeyMsg: "event.message"}).SetTimeFormat(TimeFormatUnixMillis).SetServiceName("api")
+
+	entry := logEntry(t, o, func() {
+		DebugWithFields(Fields{"error": "not found"}, "checking cache")
+		Error("upload failed")
+	})
+
+	// Recorded entries use the same names and time format as the entry they are added to
+	recorded, ok := entry[FieldKeyFlightRecorder].([]interface{})
+	if assert.True(t, ok) && assert.Len(t, recorded, 1) {
+		first := recorded[0].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"message": "checking cache"}, first["event"])
+		assert.Equal(t, "debug", first["log"].(map[string]interface{})["level"])
+		assert.IsType(t, float64(0), first["@timestamp"])
+		assert.Equal(t, map[string]interface{}{"message": "not found"}, first["error"])
+
+		// Process fields are only on the entry
+		assert.NotContains(t, first, "service")
+		assert.NotContains(t, first, "ecs")
+	}
+}
+
+func Test_WithFlightRecorder(t *testing.T) {
+
+	ctx := NewContext(context.Background(), WithFields(Fields{"request_id": "b7ad6b71"}).WithFlightRecorder(NewFlightRecorder(10)))
+
+	entries := logEntries(t, NewOptions().SetLevel("info"), func() {
+		FromContext(ctx).Debug("loading user")
+		Debug("not recorded")
+		Error("another request failed")
+		FromContext(ctx).Error("loading user failed")
+	})
+
+	if !assert.Len(t, entries, 2) {
+		return
+	}
+
+	// Only entries logged with the recorder are kept and added to its errors
+	assert.NotContains(t, entries[0], FieldKeyFlightRecorder)
+
+	recorded, ok := entries[1][FieldKeyFlightRecorder].([]interface{})
+	if assert.True(t, ok) && assert.Len(t, recorded, 1) {
+		assert.Equal(t, "loading user", recorded[0].(map[string]interface{})["msg"])
+		assert.Equal(t, "b7ad6b71", recorded[0].(map[string]interface{})["request_id"])
+	}
+}
+
+func Test_audit(t *testing.T) {
+
+	pub, key, _ := ed25519.GenerateKey(nil)
+	name := filepath.Join(t.TempDir(), "audit.log")
+	o := NewOptions().SetFile(name).SetLevel("info").SetAudit(*NewAudit().SetSigningKey(key).SetSegmentSize(2))
+
+	// The second init continues the chain in the same file
+	for _, msg := range []string{"user deleted", "user restored"} {
+		if !assert.NoError(t, InitWithOptionsE(o)) {
+			return
+		}
+		InfoWithFields(Fields{"seq": 7}, msg)
+		Warn(msg + " again")
+		assert.NoError(t, Close())
+	}
+
+	data, err := os.ReadFile(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	v := audit.NewVerifier(pub)
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	for _, line := range lines {
+		v.Line(line)
+	}
+
+	r := v.Result()
+	assert.Empty(t, r.Problems)
+	assert.Equal(t, 0, r.Unsigned)
+	assert.Equal(t, 6, r.Entries)
+	assert.Equal(t, uint64(6), r.LastSigned)
+
+	var entry map[string]interface{}
+	for _, line := range lines {
+		if bytes.Contains(line, []byte("user restored")) {
+			assert.NoError(t, json.Unmarshal(line, &entry))
+			break
+		}
+	}
+	assert.Equal(t, float64(5), entry[FieldKeySeq])
+	assert.Equal(t, float64(7), entry["fields.seq"])
+	assert.Len(t, entry[FieldKeyHash], 64)
+}
+
+// failWriter fails the writes numbered in fail counting from one
+type failWriter struct {
+	bytes.Buffer
+	writes int
+	fail   map[int]bool
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.fail[w.writes] {
+		return 0, errors.New("disk full")
+	}
+	return w.Buffer.Write(p)
+}
+
+func Test_auditWriteFailure(t *testing.T) {
+
+	pub, key, _ := ed25519.GenerateKey(nil)
+	out := &failWriter{fail: map[int]bool{2: true}}
+
+	aw, err := newAuditWriter(out, nil, NewAudit().SetSigningKey(key).SetSegmentSize(2))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, msg := range []string{"first", "lost", "second", "third"} {
+		_, err := aw.Write([]byte(`{"msg":"` + msg + `"}` + "\n"))
+		assert.Equal(t, msg == "lost", err != nil, msg)
+	}
+	assert.NoError(t, aw.Close())
+
+	// The chain continues from the last entry written rather than the lost one
+	v := audit.NewVerifier(pub)
+	for _, line := range bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n")) {
+		v.Line(line)
+	}
+
+	r := v.Result()
+	assert.Empty(t, r.Problems)
+	assert.Equal(t, 3, r.Entries)
+	assert.Equal(t, uint64(3), r.LastSigned)
+	assert.NotContains(t, out.String(), "lost")
+}
+
+func Test_encryption(t *testing.T) {
+
+	key := bytes.Repeat([]byte{1}, 32)
+	name := filepath.Join(t.TempDir(), "app.log")
+	o := NewOptions().SetFile(name).SetLevel("info").SetEncryptionKey(logcrypt.StaticKey(key))
+
+	// The second init appends to the same file
+	for _, msg := range []string{"customer updated", "customer deleted"} {
+		if !assert.NoError(t, InitWithOptionsE(o)) {
+			return
+		}
+		Info(msg)
+		assert.NoError(t, Close())
+	}
+
+	data, err := os.ReadFile(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotContains(t, string(data), "customer")
+
+	r, _ := logcrypt.NewReader(bytes.NewReader(data), key)
+	plain, err := io.ReadAll(r)
+	assert.NoError(t, err)
+
+	var msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(string(plain)), "\n") {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		msgs = append(msgs, entry[FieldKeyMsg].(string))
+	}
+	assert.Equal(t, []string{"logging started at level info", "customer updated", "logging started at level info", "customer deleted"}, msgs)
+
+	// A key which cannot be read is returned as a file error rather than writing plaintext
+	err = InitWithOptionsE(NewOptions().SetFile(name).SetEncryptionKey(logcrypt.KeyFromEnv("Test_encryption_missing")))
+	assert.IsType(t, &FileError{}, err)
+}
+
+// testSink records the entries written to it
+type testSink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func Test_sinks(t *testing.T) {
+
+	a, b := new(testSink), new(testSink)
+
+	InitWithOptions(NewOptions().SetLevel("info").AddSink(a).AddSink(b))
+	Info("both")
+
+	// Sinks removed by the next init are closed
+	InitWithOptions(NewOptions().SetLevel("info").AddSink(b))
+	Info("only b")
+	assert.True(t, a.closed)
+	assert.False(t, b.closed)
+
+	assert.NoError(t, Close())
+	assert.True(t, b.closed)
+
+	assert.Contains(t, a.String(), `"msg":"both"`)
+	assert.NotContains(t, a.String(), `"msg":"only b"`)
+	assert.Contains(t, b.String(), `"msg":"both"`)
+	assert.Contains(t, b.String(), `"msg":"only b"`)
+}
+
+func Test_ECS(t *testing.T) {
+
+	o := NewOptions().SetLevel("info").SetIncludeFunc(true).SetECS(true).SetStackTrace(*NewStackTrace().SetMinLevel("error")).
+		SetFieldMap(FieldMap{FieldKeyTime: "timestamp"})
+
+	entries := logEntries(t, o, func() {
+		ErrorWithFields(Fields{"error": errors.New("disk full"), "user": "b7ad6b71"}, "upload failed")
+	})
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	e := entries[0]
+
+	assert.Equal(t, "upload failed", e["message"])
+	assert.Equal(t, "b7ad6b71", e["user"])
+	assert.Equal(t, map[string]interface{}{"version": ECSVersion}, e["ecs"])
+	assert.Equal(t, "error", e["log"].(map[string]interface{})["level"])
+
+	// FieldMap replaces the ECS names
+	assert.NotEmpty(t, e["timestamp"])
+	assert.NotContains(t, e, "@timestamp")
+
+	origin := e["log"].(map[string]interface{})["origin"].(map[string]interface{})
+	assert.Equal(t, "logger.Test_ECS.func1", origin["function"])
+	assert.Contains(t, origin["file"].(map[string]interface{})["name"], "logger_test.go")
+	assert.NotEmpty(t, origin["file"].(map[string]interface{})["line"])
+
+	errorFields := e["error"].(map[string]interface{})
+	assert.Equal(t, "disk full", errorFields["message"])
+	assert.IsType(t, "", errorFields["stack_trace"])
+}
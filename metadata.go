@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"os"
+)
+
+// collectMetadata gets the process fields to add to every entry based on the options
+func collectMetadata(o *Options) Fields {
+
+	fields := Fields{}
+
+	if o.GetIncludeHostname() {
+		if hostname, err := os.Hostname(); err == nil {
+			fields[FieldKeyHostname] = hostname
+		}
+	}
+
+	if o.GetIncludePID() {
+		fields[FieldKeyPID] = os.Getpid()
+	}
+
+	if o.ServiceName != nil {
+		fields[FieldKeyService] = o.GetServiceName()
+	}
+
+	if o.ServiceVersion != nil {
+		fields[FieldKeyVersion] = o.GetServiceVersion()
+	}
+
+	if o.Environment != nil {
+		fields[FieldKeyEnvironment] = o.GetEnvironment()
+	}
+
+	if o.GetIncludeKubernetes() {
+		env := podEnv{getenv: os.Getenv, podInfoDir: o.GetPodInfoDir()}
+		for k, v := range env.kubernetesFields() {
+			fields[k] = v
+		}
+	}
+
+	if o.GetIncludeBuildInfo() {
+		for k, v := range buildInfoFields() {
+			fields[k] = v
+		}
+	}
+
+	return fields
+}
@@ -1,5 +1,16 @@
 package logger
 
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/realugbun/logger/logcrypt"
+)
+
 // Options for initiating the logger
 type Options struct {
 
@@ -14,12 +25,141 @@ type Options struct {
 
 	// StackTrace options for including stack traces
 	StackTrace *StackTrace
+
+	// FilePath sets how file names are written in the caller and trace fields: full, module, or short.
+	// Defaults to full.
+	FilePath *string
+
+	// TrimPrefix removes a prefix such as the build directory from file names in the caller and trace fields
+	TrimPrefix *string
+
+	// CallerSkip is the number of extra frames to skip when finding the caller.
+	// Use it when a wrapper function calls the logger from a package which is not skipped.
+	CallerSkip *int
+
+	// FieldCollision sets how custom fields using the same keys as the caller and trace fields are handled:
+	// prefix or nest. Defaults to prefix.
+	FieldCollision *string
+
+	// FieldMap renames the fields written by the logger ie FieldKeyTime to timestamp.
+	// Names containing dots are nested ie FieldKeyCaller to logger.caller.
+	FieldMap FieldMap
+
+	// ECS writes entries in the Elastic Common Schema. The fields written by the logger use the ECS names
+	// ie log.level and log.origin.file.name unless FieldMap renames them, ecs.version is added, and a custom
+	// error field is written as error.message. Stack traces default to the panic format so error.stack_trace
+	// is a string. The fields format cannot be used with it.
+	ECS *bool
+
+	// FieldsKey nests all custom fields under the key ie fields. Keys containing dots are nested.
+	FieldsKey *string
+
+	// TimeFormat sets how the time field is written: a layout for time.Format, unix_ms, or unix_ns.
+	// Defaults to RFC3339.
+	TimeFormat *string
+
+	// UTC writes the time field in UTC instead of local time
+	UTC *bool
+
+	// Elapsed adds an elapsed field with the nanoseconds since the process started.
+	// It uses the monotonic clock so it keeps increasing when the wall clock is changed.
+	Elapsed *bool
+
+	// StaticFields are custom fields added to every entry. Fields passed to a log call replace them.
+	StaticFields Fields
+
+	// IncludeHostname adds the hostname to every entry
+	IncludeHostname *bool
+
+	// IncludePID adds the process id to every entry
+	IncludePID *bool
+
+	// ServiceName adds the name of the service to every entry
+	ServiceName *string
+
+	// ServiceVersion adds the version of the service to every entry
+	ServiceVersion *string
+
+	// Environment adds the deployment environment ie production to every entry
+	Environment *string
+
+	// IncludeBuildInfo adds the Go version, module version, and VCS revision from the build info to every entry
+	IncludeBuildInfo *bool
+
+	// IncludeKubernetes adds the pod name, namespace, node, labels, and container id to every entry
+	// when running in Kubernetes or a container
+	IncludeKubernetes *bool
+
+	// PodInfoDir is where the downward API volume with the labels file is mounted. Defaults to /etc/podinfo.
+	PodInfoDir *string
+
+	// FallbackOutput receives messages when File cannot be opened. Defaults to standard error.
+	FallbackOutput io.Writer
+
+	// SkipPackages are import paths of packages that wrap the logger ie github.com/me/app/logutil.
+	// Their frames are skipped like the logger's own so the caller is the code that called the wrapper.
+	SkipPackages []string
+
+	// FlightRecorder is the number of Debug and Trace entries below the log level kept in memory.
+	// They are added to the next Error, Fatal, or Panic entry so debug detail is only written when something fails.
+	FlightRecorder *int
+
+	// Audit adds a sequence number and a hash chaining each entry to the previous one so changes to the log can be detected
+	Audit *Audit
+
+	// EncryptionKey provides the key used to encrypt File with AES-GCM. Read it with logcrypt.NewReader or cmd/logdecrypt.
+	EncryptionKey logcrypt.KeySource
+
+	// Sinks receive every entry as well as the output ie to ship entries to a collector. Write errors are ignored
+	// so a sink should report them itself. Sinks are closed when they are removed by a later init and by Close.
+	Sinks []io.WriteCloser
+
+	// formatter lays out the entries. It is set on the options in use by init.
+	formatter *formatter
 }
 
 func NewOptions() *Options {
 	return new(Options)
 }
 
+// getFormatter gets the formatter set by init or a new one when the logger has not been initialized
+func (o *Options) getFormatter() *formatter {
+	if o.formatter == nil {
+		return newFormatter(o)
+	}
+	return o.formatter
+}
+
+// clone copies the options so changes made after init are not seen while logging.
+// Setters replace pointers instead of writing through them so a shallow copy is enough
+// except for maps and slices.
+func (o *Options) clone() *Options {
+	c := *o
+	if o.StackTrace != nil {
+		st := *o.StackTrace
+		c.StackTrace = &st
+	}
+	if o.Audit != nil {
+		a := *o.Audit
+		c.Audit = &a
+	}
+	c.SkipPackages = append([]string(nil), o.SkipPackages...)
+	c.Sinks = append([]io.WriteCloser(nil), o.Sinks...)
+	if o.FieldMap != nil {
+		c.FieldMap = make(FieldMap, len(o.FieldMap))
+		for k, v := range o.FieldMap {
+			c.FieldMap[k] = v
+		}
+	}
+	if o.StaticFields != nil {
+		c.StaticFields = make(Fields, len(o.StaticFields))
+		for k, v := range o.StaticFields {
+			c.StaticFields[k] = v
+		}
+	}
+	return &c
+}
+
 func (o *Options) SetFile(file string) *Options {
 	o.File = &file
 	return o
@@ -53,11 +193,388 @@ func (o *Options) GetLevel() string {
 	return *o.Level
 }
 
+func (o *Options) SetCallerSkip(i int) *Options {
+	o.CallerSkip = &i
+	return o
+}
+
+// AddCallerSkip increases the number of frames to skip when finding the caller
+func (o *Options) AddCallerSkip(i int) *Options {
+	return o.SetCallerSkip(o.GetCallerSkip() + i)
+}
+
+func (o *Options) GetCallerSkip() int {
+	if o.CallerSkip == nil {
+		return 0
+	}
+	return *o.CallerSkip
+}
+
+func (o *Options) AddSkipPackages(pkgs ...string) *Options {
+	o.SkipPackages = append(o.SkipPackages, pkgs...)
+	return o
+}
+
+func (o *Options) SetFieldCollision(policy string) *Options {
+	o.FieldCollision = &policy
+	return o
+}
+
+func (o *Options) GetFieldCollision() string {
+	if o.FieldCollision == nil {
+		return FieldCollisionPrefix
+	}
+	return *o.FieldCollision
+}
+
+func (o *Options) SetFieldMap(m FieldMap) *Options {
+	o.FieldMap = m
+	return o
+}
+
+func (o *Options) SetECS(b bool) *Options {
+	o.ECS = &b
+	return o
+}
+
+func (o *Options) GetECS() bool {
+	if o.ECS == nil {
+		return false
+	}
+	return *o.ECS
+}
+
+func (o *Options) SetFieldsKey(key string) *Options {
+	o.FieldsKey = &key
+	return o
+}
+
+func (o *Options) GetFieldsKey() string {
+	if o.FieldsKey == nil {
+		return ""
+	}
+	return *o.FieldsKey
+}
+
+func (o *Options) SetTimeFormat(format string) *Options {
+	o.TimeFormat = &format
+	return o
+}
+
+func (o *Options) GetTimeFormat() string {
+	if o.TimeFormat == nil {
+		return TimeFormatRFC3339
+	}
+	return *o.TimeFormat
+}
+
+func (o *Options) SetUTC(b bool) *Options {
+	o.UTC = &b
+	return o
+}
+
+func (o *Options) GetUTC() bool {
+	if o.UTC == nil {
+		return false
+	}
+	return *o.UTC
+}
+
+func (o *Options) SetElapsed(b bool) *Options {
+	o.Elapsed = &b
+	return o
+}
+
+func (o *Options) GetElapsed() bool {
+	if o.Elapsed == nil {
+		return false
+	}
+	return *o.Elapsed
+}
+
+func (o *Options) SetStaticFields(fields Fields) *Options {
+	o.StaticFields = fields
+	return o
+}
+
+func (o *Options) SetIncludeHostname(b bool) *Options {
+	o.IncludeHostname = &b
+	return o
+}
+
+func (o *Options) GetIncludeHostname() bool {
+	if o.IncludeHostname == nil {
+		return false
+	}
+	return *o.IncludeHostname
+}
+
+func (o *Options) SetIncludePID(b bool) *Options {
+	o.IncludePID = &b
+	return o
+}
+
+func (o *Options) GetIncludePID() bool {
+	if o.IncludePID == nil {
+		return false
+	}
+	return *o.IncludePID
+}
+
+func (o *Options) SetServiceName(name string) *Options {
+	o.ServiceName = &name
+	return o
+}
+
+func (o *Options) GetServiceName() string {
+	if o.ServiceName == nil {
+		return ""
+	}
+	return *o.ServiceName
+}
+
+func (o *Options) SetServiceVersion(version string) *Options {
+	o.ServiceVersion = &version
+	return o
+}
+
+func (o *Options) GetServiceVersion() string {
+	if o.ServiceVersion == nil {
+		return ""
+	}
+	return *o.ServiceVersion
+}
+
+func (o *Options) SetEnvironment(env string) *Options {
+	o.Environment = &env
+	return o
+}
+
+func (o *Options) GetEnvironment() string {
+	if o.Environment == nil {
+		return ""
+	}
+	return *o.Environment
+}
+
+func (o *Options) SetIncludeBuildInfo(b bool) *Options {
+	o.IncludeBuildInfo = &b
+	return o
+}
+
+func (o *Options) GetIncludeBuildInfo() bool {
+	if o.IncludeBuildInfo == nil {
+		return false
+	}
+	return *o.IncludeBuildInfo
+}
+
+func (o *Options) SetIncludeKubernetes(b bool) *Options {
+	o.IncludeKubernetes = &b
+	return o
+}
+
+func (o *Options) GetIncludeKubernetes() bool {
+	if o.IncludeKubernetes == nil {
+		return false
+	}
+	return *o.IncludeKubernetes
+}
+
+func (o *Options) SetPodInfoDir(dir string) *Options {
+	o.PodInfoDir = &dir
+	return o
+}
+
+func (o *Options) GetPodInfoDir() string {
+	if o.PodInfoDir == nil {
+		return DefaultPodInfoDir
+	}
+	return *o.PodInfoDir
+}
+
+func (o *Options) SetFallbackOutput(w io.Writer) *Options {
+	o.FallbackOutput = w
+	return o
+}
+
+func (o *Options) GetFallbackOutput() io.Writer {
+	if o.FallbackOutput == nil {
+		return os.Stderr
+	}
+	return o.FallbackOutput
+}
+
 func (o *Options) SetStackTrace(options StackTrace) *Options {
 	o.StackTrace = &options
 	return o
 }
 
+func (o *Options) SetFilePath(format string) *Options {
+	o.FilePath = &format
+	return o
+}
+
+func (o *Options) GetFilePath() string {
+	if o.FilePath == nil {
+		return FilePathFull
+	}
+	return *o.FilePath
+}
+
+func (o *Options) SetTrimPrefix(prefix string) *Options {
+	o.TrimPrefix = &prefix
+	return o
+}
+
+func (o *Options) GetTrimPrefix() string {
+	if o.TrimPrefix == nil {
+		return ""
+	}
+	return *o.TrimPrefix
+}
+
+func (o *Options) SetFlightRecorder(size int) *Options {
+	o.FlightRecorder = &size
+	return o
+}
+
+func (o *Options) GetFlightRecorder() int {
+	if o.FlightRecorder == nil {
+		return 0
+	}
+	return *o.FlightRecorder
+}
+
+func (o *Options) SetAudit(audit Audit) *Options {
+	o.Audit = &audit
+	return o
+}
+
+func (o *Options) SetEncryptionKey(source logcrypt.KeySource) *Options {
+	o.EncryptionKey = source
+	return o
+}
+
+// AddSink adds a sink which receives every entry
+func (o *Options) AddSink(sink io.WriteCloser) *Options {
+	o.Sinks = append(o.Sinks, sink)
+	return o
+}
+
+// Validate checks the options for invalid values and settings which contradict each other.
+// It returns a *LevelError or *OptionError for the first problem found.
+func (o *Options) Validate() error {
+
+	if o.Level != nil {
+		if _, err := logrus.ParseLevel(strings.ToLower(o.GetLevel())); err != nil {
+			return &LevelError{Option: "Level", Level: o.GetLevel()}
+		}
+	}
+
+	if o.File != nil && o.GetFile() == "" {
+		return &OptionError{Option: "File", Reason: "file name is empty"}
+	}
+
+	switch o.GetFilePath() {
+	case FilePathFull, FilePathModule, FilePathShort:
+	default:
+		return &OptionError{Option: "FilePath", Reason: "must be full, module, or short"}
+	}
+
+	switch o.GetFieldCollision() {
+	case FieldCollisionPrefix, FieldCollisionNest:
+	default:
+		return &OptionError{Option: "FieldCollision", Reason: "must be prefix or nest"}
+	}
+
+	if o.GetTimeFormat() == "" {
+		return &OptionError{Option: "TimeFormat", Reason: "must not be empty"}
+	}
+
+	for k, name := range o.FieldMap {
+		if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+			return &OptionError{Option: "FieldMap", Reason: fmt.Sprintf("invalid name %q for %s", name, k)}
+		}
+	}
+
+	if o.GetECS() && o.GetFieldCollision() == FieldCollisionNest {
+		return &OptionError{Option: "ECS", Reason: "cannot be used with FieldCollision nest"}
+	}
+
+	// error.stack_trace is a string field which an array of objects does not fit
+	if o.GetECS() && o.StackTrace != nil && o.StackTrace.Format != nil && o.StackTrace.GetFormat() == TraceFormatFields {
+		return &OptionError{Option: "ECS", Reason: "cannot be used with StackTrace.Format fields"}
+	}
+
+	if o.GetCallerSkip() < 0 {
+		return &OptionError{Option: "CallerSkip", Reason: "must not be negative"}
+	}
+
+	if o.GetFlightRecorder() < 0 {
+		return &OptionError{Option: "FlightRecorder", Reason: "must not be negative"}
+	}
+
+	if o.Audit != nil {
+		if err := o.Audit.validate(); err != nil {
+			return err
+		}
+	}
+
+	if o.EncryptionKey != nil {
+		if o.File == nil {
+			return &OptionError{Option: "EncryptionKey", Reason: "only file output is encrypted"}
+		}
+		if o.Audit != nil {
+			return &OptionError{Option: "EncryptionKey", Reason: "cannot be used with Audit"}
+		}
+	}
+
+	if o.StackTrace == nil {
+		return nil
+	}
+
+	if !o.GetIncludeFunc() {
+		return &OptionError{Option: "StackTrace", Reason: "stack traces are only added when IncludeFunc is enabled"}
+	}
+
+	return o.StackTrace.validate()
+}
+
+// validate checks the stack trace options
+func (s *StackTrace) validate() error {
+
+	if s.MinLevel != nil {
+		if _, err := logrus.ParseLevel(strings.ToLower(s.GetMinLevel())); err != nil {
+			return &LevelError{Option: "StackTrace.MinLevel", Level: s.GetMinLevel()}
+		}
+	}
+
+	switch s.GetFormat() {
+	case TraceFormatFields, TraceFormatPanic, TraceFormatCompact:
+	default:
+		return &OptionError{Option: "StackTrace.Format", Reason: "must be fields, panic, or compact"}
+	}
+
+	if s.GetMaxEntries() < 0 {
+		return &OptionError{Option: "StackTrace.MaxEntries", Reason: "must not be negative"}
+	}
+
+	if s.GetDepth() < 1 {
+		return &OptionError{Option: "StackTrace.Depth", Reason: "must be at least 1"}
+	}
+
+	if s.GetMaxEntries() > s.GetDepth() {
+		return &OptionError{Option: "StackTrace.MaxEntries", Reason: "can never be reached because it is more than Depth"}
+	}
+
+	if s.GetLambda() && s.StopFunction != nil && s.GetStopFunction() != lambdaStopFunction {
+		return &OptionError{Option: "StackTrace.Lambda", Reason: "replaces the StopFunction which is also set"}
+	}
+
+	return nil
+}
+
 // StackTrace sets options for stack traceing
 type StackTrace struct {
 
@@ -72,6 +589,23 @@ type StackTrace struct {
 
 	// Lambda sets stop function or stop file variables for AWS lambda
 	Lambda *bool
+
+	// OmitGoRoot leaves frames from the Go runtime and standard library out of the trace
+	OmitGoRoot *bool
+
+	// Depth sets the maximum number of frames read from the call stack including the logger's own frames.
+	// Traces cut short by Depth or MaxEntries are marked with trace_truncated. Defaults to 100.
+	Depth *int
+
+	// AllGoroutines adds the stacks of every goroutine to Panic and Fatal messages
+	AllGoroutines *bool
+
+	// Format sets how the trace field is written: fields, panic, or compact. Defaults to fields.
+	Format *string
+
+	// MinLevel is the least severe level which includes a stack trace ie error adds traces to Error, Panic, and Fatal.
+	// Defaults to trace so every message includes a stack trace.
+	MinLevel *string
 }
 
 func NewStackTrace() *StackTrace {
@@ -125,3 +659,73 @@ func (s *StackTrace) GetLambda() bool {
 	}
 	return *s.Lambda
 }
+
+func (s *StackTrace) SetOmitGoRoot(b bool) *StackTrace {
+	s.OmitGoRoot = &b
+	return s
+}
+
+func (s *StackTrace) GetOmitGoRoot() bool {
+	if s.OmitGoRoot == nil {
+		return false
+	}
+	return *s.OmitGoRoot
+}
+
+func (s *StackTrace) SetDepth(i int) *StackTrace {
+	s.Depth = &i
+	return s
+}
+
+func (s *StackTrace) GetDepth() int {
+	if s.Depth == nil {
+		return defaultDepth
+	}
+	return *s.Depth
+}
+
+func (s *StackTrace) SetAllGoroutines(b bool) *StackTrace {
+	s.AllGoroutines = &b
+	return s
+}
+
+func (s *StackTrace) GetAllGoroutines() bool {
+	if s.AllGoroutines == nil {
+		return false
+	}
+	return *s.AllGoroutines
+}
+
+func (s *StackTrace) SetMinLevel(level string) *StackTrace {
+	s.MinLevel = &level
+	return s
+}
+
+func (s *StackTrace) GetMinLevel() string {
+	if s.MinLevel == nil {
+		return logrus.TraceLevel.String()
+	}
+	return *s.MinLevel
+}
+
+// includesLevel checks if messages at the level should include a stack trace.
+// An invalid MinLevel includes every level.
+func (s *StackTrace) includesLevel(level logrus.Level) bool {
+	min, err := logrus.ParseLevel(strings.ToLower(s.GetMinLevel()))
+	if err != nil {
+		return true
+	}
+	return level <= min
+}
+
+func (s *StackTrace) SetFormat(format string) *StackTrace {
+	s.Format = &format
+	return s
+}
+
+func (s *StackTrace) GetFormat() string {
+	if s.Format == nil {
+		return TraceFormatFields
+	}
+	return *s.Format
+}
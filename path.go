@@ -0,0 +1,148 @@
+package logger
+
+import (
+	"path"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// File path formats for the file names included in the caller and trace fields
+const (
+	// FilePathFull uses the absolute path reported by the runtime ie /Users/me/go/src/github.com/me/app/main.go
+	FilePathFull = "full"
+
+	// FilePathModule uses the path relative to the main module root ie cmd/app/main.go.
+	// Files outside the main module use their package path ie github.com/sirupsen/logrus/entry.go
+	FilePathModule = "module"
+
+	// FilePathShort uses only the package directory and file name ie app/main.go
+	FilePathShort = "short"
+)
+
+var (
+	buildPathsOnce sync.Once
+	mainModule     string
+	mainPackage    string
+
+	// modules are the paths of the main module and its dependencies from the build info
+	modules []string
+)
+
+// formatPath formats a file name from a stack frame based on the logger options.
+// The function name of the frame is used to find the package the file belongs to.
+func formatPath(o *Options, file, function string) string {
+
+	switch o.GetFilePath() {
+	case FilePathModule:
+		file = modulePath(file, function)
+	case FilePathShort:
+		file = shortPath(file)
+	}
+
+	return strings.TrimPrefix(file, o.GetTrimPrefix())
+}
+
+// modulePath returns the file name relative to the main module root using the build info.
+// Files outside of the main module are prefixed with their package path instead.
+func modulePath(file, function string) string {
+
+	readBuildPaths()
+
+	pkg := packageName(function)
+	if pkg == "" {
+		return shortPath(file)
+	}
+
+	// Functions in package main are reported as main.func so use the main package path from the build
+	if pkg == "main" && mainPackage != "" {
+		pkg = mainPackage
+	}
+
+	// External test packages live in the same directory as the package they test
+	pkg = strings.TrimSuffix(pkg, "_test")
+
+	name := path.Base(file)
+
+	if mainModule != "" {
+		if pkg == mainModule {
+			return name
+		}
+		if strings.HasPrefix(pkg, mainModule+"/") {
+			return strings.TrimPrefix(pkg, mainModule+"/") + "/" + name
+		}
+	}
+
+	return pkg + "/" + name
+}
+
+// readBuildPaths reads the main package and module paths from the build info the first time it is called
+func readBuildPaths() {
+	buildPathsOnce.Do(func() {
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		mainModule = bi.Main.Path
+		mainPackage = bi.Path
+		if mainModule != "" {
+			modules = append(modules, mainModule)
+		}
+		for _, m := range bi.Deps {
+			modules = append(modules, m.Path)
+		}
+	})
+}
+
+// shortPath returns the last directory and the file name
+func shortPath(file string) string {
+
+	i := strings.LastIndex(file, "/")
+	if i == -1 {
+		return file
+	}
+	i = strings.LastIndex(file[:i], "/")
+	if i == -1 {
+		return file
+	}
+	return file[i+1:]
+}
+
+// packageName gets the package path from a fully qualified function name
+// ie github.com/realugbun/logger.(*Options).SetFile returns github.com/realugbun/logger.
+// The runtime escapes dots in the last element of the path as %2e ie gopkg.in/yaml%2ev3.Marshal
+// so the package ends at the first dot after the last slash.
+func packageName(function string) string {
+
+	slash := strings.LastIndex(function, "/")
+	dot := strings.Index(function[slash+1:], ".")
+	if dot == -1 {
+		return ""
+	}
+	return function[:slash+1] + strings.ReplaceAll(function[slash+1:slash+1+dot], "%2e", ".")
+}
+
+// isGoRoot checks if a function belongs to the Go runtime or standard library.
+// Standard library package paths never contain a dot in their first element. Modules may also
+// have a path without a dot ie module myapp so packages from the modules in the build are excluded.
+func isGoRoot(function string) bool {
+
+	pkg := packageName(function)
+	if pkg == "" || pkg == "main" {
+		return false
+	}
+
+	first := strings.SplitN(pkg, "/", 2)[0]
+	if strings.Contains(first, ".") {
+		return false
+	}
+
+	readBuildPaths()
+	for _, m := range modules {
+		if pkg == m || strings.HasPrefix(pkg, m+"/") {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,411 @@
+// Package sink sends log entries to remote services. Sinks are added to the logger with Options.AddSink.
+//
+// Entries are collected into batches which are sent in the background when they reach Options.MaxEntries or
+// Options.MaxBytes, or after Options.FlushInterval. Failed batches are retried with exponential backoff and
+// jitter then reported to Options.OnError, or kept on disk when Options.SpoolDir is set and sent once the
+// service recovers. Close sends the remaining entries.
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when writing to a closed sink
+var ErrClosed = errors.New("sink: closed")
+
+// BatchError is reported to Options.OnError when entries were not sent
+type BatchError struct {
+	// Entries is the number of entries which were not sent
+	Entries int
+
+	// Attempts is the number of times the batch was sent. It is 0 when entries were dropped because
+	// MaxPending or SpoolMaxBytes was reached, or the spool could not be read.
+	Attempts int
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	if e.Entries == 0 {
+		return fmt.Sprintf("sink: %v", e.Err)
+	}
+	if e.Attempts == 0 {
+		return fmt.Sprintf("sink: dropped %d entries: %v", e.Entries, e.Err)
+	}
+	return fmt.Sprintf("sink: failed to send %d entries after %d attempts: %v", e.Entries, e.Attempts, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// errPendingFull is the reason entries are dropped when MaxPending is reached
+var errPendingFull = errors.New("too many entries waiting to be sent")
+
+// permanent marks an error which will not succeed if the batch is sent again
+type permanent interface {
+	Permanent() bool
+}
+
+// partial is an error from a send where only some entries failed. The others were accepted
+// or rejected so only the remaining entries are sent again.
+type partial interface {
+	Remaining() [][]byte
+}
+
+// sendFunc sends a batch of entries, each without its new line
+type sendFunc func(ctx context.Context, entries [][]byte) error
+
+// batcher collects entries and sends them in batches from a background goroutine
+type batcher struct {
+	o    *Options
+	send sendFunc
+
+	// spool keeps batches which could not be sent. It is only used by the goroutine.
+	spool *spool
+
+	// drainAt is when sending the spool is next tried and drainFailures the number of tries which failed since
+	// the last batch was sent
+	drainAt       time.Time
+	drainFailures int
+
+	mu      sync.Mutex
+	pending [][]byte
+	size    int
+	closed  bool
+
+	// ctx is used for every send. It is cancelled when Close has waited CloseTimeout.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// flush asks the goroutine to send the pending entries. The channel is closed when they have been sent.
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newBatcher starts sending batches with send. The spool is opened when SpoolDir is set.
+func newBatcher(o *Options, send sendFunc) (*batcher, error) {
+
+	b := &batcher{
+		o:     o,
+		send:  send,
+		flush: make(chan chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	if o.SpoolDir != nil {
+		var err error
+		if b.spool, err = openSpool(o.GetSpoolDir(), o.GetSpoolMaxBytes()); err != nil {
+			return nil, err
+		}
+		if b.spool.checkpointErr != nil {
+			b.report(&BatchError{Err: b.spool.checkpointErr})
+		}
+	}
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	go b.run()
+	return b, nil
+}
+
+// Write adds an entry to the next batch. It does not wait for the entry to be sent.
+func (b *batcher) Write(p []byte) (int, error) {
+
+	entry := append([]byte(nil), bytes.TrimRight(p, "\n")...)
+
+	b.mu.Lock()
+
+	if b.closed {
+		b.mu.Unlock()
+		return 0, ErrClosed
+	}
+
+	b.pending = append(b.pending, entry)
+	b.size += len(entry)
+
+	var dropped int
+	for len(b.pending) > b.o.GetMaxPending() {
+		b.size -= len(b.pending[0])
+		b.pending[0] = nil
+		b.pending = b.pending[1:]
+		dropped++
+	}
+
+	full := len(b.pending) >= b.o.GetMaxEntries() || b.size >= b.o.GetMaxBytes()
+
+	b.mu.Unlock()
+
+	if dropped > 0 {
+		b.report(&BatchError{Entries: dropped, Err: errPendingFull})
+	}
+
+	if full {
+		select {
+		case b.flush <- nil:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush sends the pending entries and waits until they are sent or have failed
+func (b *batcher) Flush() error {
+
+	req := make(chan struct{})
+	select {
+	case b.flush <- req:
+	case <-b.done:
+		return ErrClosed
+	}
+
+	select {
+	case <-req:
+	case <-b.done:
+	}
+	return nil
+}
+
+// Close sends the pending entries then stops. It waits at most CloseTimeout including for a batch which is being
+// sent or retried when it is called. Entries which are not sent by then are kept in the spool or reported to OnError.
+func (b *batcher) Close() error {
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+
+	t := time.AfterFunc(b.o.GetCloseTimeout(), b.cancel)
+	<-b.done
+	t.Stop()
+	b.cancel()
+	return nil
+}
+
+func (b *batcher) run() {
+
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.o.GetFlushInterval())
+	defer ticker.Stop()
+
+	// Batches spooled before a restart are sent first
+	b.drain(b.ctx)
+
+	for {
+		select {
+
+		case <-ticker.C:
+			b.sendPending(b.ctx)
+			b.drain(b.ctx)
+
+		case req := <-b.flush:
+			b.sendPending(b.ctx)
+			if req != nil {
+				close(req)
+			}
+
+		case <-b.stop:
+			b.sendPending(b.ctx)
+			b.drain(b.ctx)
+			if b.spool != nil {
+				b.spool.close()
+			}
+			return
+		}
+	}
+}
+
+// sendPending sends batches until there are no pending entries
+func (b *batcher) sendPending(ctx context.Context) {
+	for {
+		batch := b.next()
+		if len(batch) == 0 {
+			return
+		}
+		b.deliver(ctx, batch)
+	}
+}
+
+// next takes the next batch from the pending entries
+func (b *batcher) next() [][]byte {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, size := 0, 0
+	for n < len(b.pending) && n < b.o.GetMaxEntries() {
+
+		// An entry larger than MaxBytes is sent in a batch of its own
+		if n > 0 && size+len(b.pending[n]) > b.o.GetMaxBytes() {
+			break
+		}
+		size += len(b.pending[n])
+		n++
+	}
+
+	batch := b.pending[:n:n]
+	b.pending = b.pending[n:]
+	b.size -= size
+	return batch
+}
+
+// deliver sends a batch. Failed batches are kept in the spool when there is one. While the spool has
+// batches new ones are added after them so they are sent in order.
+func (b *batcher) deliver(ctx context.Context, batch [][]byte) {
+
+	if b.spool != nil && !b.spool.empty() {
+		b.push(batch)
+		b.drain(ctx)
+		return
+	}
+
+	attempts, batch, err := b.retry(ctx, batch)
+	if err == nil {
+		return
+	}
+
+	if b.spool != nil && !isPermanent(err) {
+		b.push(batch)
+		b.drainFailures = 1
+		b.drainAt = time.Now().Add(b.backoff(b.drainFailures))
+		return
+	}
+
+	b.report(&BatchError{Entries: len(batch), Attempts: attempts, Err: err})
+}
+
+// push adds a batch to the spool
+func (b *batcher) push(batch [][]byte) {
+	if err := b.spool.push(batch); err != nil {
+		b.report(&BatchError{Entries: len(batch), Err: err})
+	}
+}
+
+// drain sends the spooled batches in order until one fails. After a failure it waits with backoff
+// before trying again.
+func (b *batcher) drain(ctx context.Context) {
+
+	for b.spool != nil && !b.spool.empty() && !time.Now().Before(b.drainAt) {
+
+		batch, err := b.spool.peek()
+		if err != nil {
+			b.report(&BatchError{Err: fmt.Errorf("reading spool: %w", err)})
+			if err == errCorrupt {
+				continue
+			}
+			return
+		}
+		if batch == nil {
+			return
+		}
+
+		// A record which partly failed is sent again in full so entries may be repeated
+		err = b.send(ctx, batch)
+		if err != nil && !isPermanent(err) {
+			b.drainFailures++
+			b.drainAt = time.Now().Add(b.backoff(b.drainFailures))
+			return
+		}
+
+		// A batch the service rejects is dropped so it does not hold up the rest
+		if err != nil {
+			b.report(&BatchError{Entries: len(batch), Attempts: 1, Err: err})
+		}
+
+		b.drainFailures = 0
+		if err := b.spool.pop(); err != nil {
+			b.report(&BatchError{Err: fmt.Errorf("updating spool: %w", err)})
+			return
+		}
+	}
+}
+
+// retry sends a batch, retrying with backoff until it succeeds, fails permanently, or ctx is done.
+// It returns the number of attempts, the entries which were not sent, and the last error.
+func (b *batcher) retry(ctx context.Context, batch [][]byte) (int, [][]byte, error) {
+
+	var err error
+	attempt := 0
+	for {
+		attempt++
+		if err = b.send(ctx, batch); err == nil {
+			return attempt, nil, nil
+		}
+
+		var p partial
+		if errors.As(err, &p) {
+			batch = p.Remaining()
+		}
+
+		if isPermanent(err) || attempt > b.o.GetMaxRetries() {
+			break
+		}
+
+		wait := b.backoff(attempt)
+		var r retryAfter
+		if errors.As(err, &r) && r.RetryAfter() > wait {
+			wait = r.RetryAfter()
+			if wait > b.o.GetMaxBackoff() {
+				wait = b.o.GetMaxBackoff()
+			}
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+			continue
+		case <-ctx.Done():
+			t.Stop()
+		}
+		break
+	}
+
+	return attempt, batch, err
+}
+
+func isPermanent(err error) bool {
+	var p permanent
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// retryAfter is an error which says how long to wait before sending again
+type retryAfter interface {
+	RetryAfter() time.Duration
+}
+
+// backoff is the wait before a retry. It doubles with each attempt up to MaxBackoff with jitter of up to half.
+func (b *batcher) backoff(attempt int) time.Duration {
+
+	d := b.o.GetMinBackoff()
+	for i := 1; i < attempt && d < b.o.GetMaxBackoff(); i++ {
+		d *= 2
+	}
+	if d > b.o.GetMaxBackoff() {
+		d = b.o.GetMaxBackoff()
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (b *batcher) report(err error) {
+	if b.o.OnError != nil {
+		b.o.OnError(err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
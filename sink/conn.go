@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// dialTimeout is the longest wait to connect
+	dialTimeout = 10 * time.Second
+
+	// ioTimeout is the longest wait to write a batch or read a reply when the context has no deadline
+	ioTimeout = 30 * time.Second
+)
+
+// netConn is a connection which is dialed when first used and again after an error. It is only used
+// by the batcher goroutine then closed with the sink.
+type netConn struct {
+	network string
+	addr    string
+	c       net.Conn
+}
+
+// get connects if there is no connection and sets the deadline for the next write and read
+func (n *netConn) get(ctx context.Context) (net.Conn, error) {
+
+	if n.c == nil {
+		d := net.Dialer{Timeout: dialTimeout}
+		c, err := d.DialContext(ctx, n.network, n.addr)
+		if err != nil {
+			return nil, err
+		}
+		n.c = c
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(ioTimeout)
+	}
+	if err := n.c.SetDeadline(deadline); err != nil {
+		n.close()
+		return nil, err
+	}
+
+	return n.c, nil
+}
+
+// interrupt ends a write or read in progress when ctx is done so Close is not held up by a server which stopped
+// reading. Call the returned function once the batch has been sent.
+func (n *netConn) interrupt(ctx context.Context) func() {
+
+	c := n.c
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// close closes the connection so the next batch connects again
+func (n *netConn) close() {
+	if n.c != nil {
+		n.c.Close()
+		n.c = nil
+	}
+}
+
+// remainingError is returned when a send failed part way through a batch. The entries before it
+// were sent so only the rest are sent again.
+type remainingError struct {
+	err       error
+	remaining [][]byte
+}
+
+func (e *remainingError) Error() string {
+	return e.err.Error()
+}
+
+func (e *remainingError) Unwrap() error {
+	return e.err
+}
+
+// Remaining gets the entries to send again
+func (e *remainingError) Remaining() [][]byte {
+	return e.remaining
+}
@@ -0,0 +1,221 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/realugbun/logger"
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+const (
+	defaultIndex      = "logs"
+	defaultDateLayout = "2006.01.02"
+
+	// bulkPath only returns the fields needed to find failed entries
+	bulkPath = "/_bulk?filter_path=errors,items.*.status,items.*.error"
+
+	// ecsTimestamp is the time field written with the ECS option
+	ecsTimestamp = "@timestamp"
+)
+
+// ElasticsearchOptions choose the indexes entries are written to
+type ElasticsearchOptions struct {
+
+	// Index is the start of the daily index names ie logs writes to logs-2022.03.04. Defaults to logs.
+	Index *string
+
+	// DateLayout is the layout of the date added to Index from the time of each entry in UTC.
+	// Defaults to 2006.01.02 for daily indexes.
+	DateLayout *string
+}
+
+func NewElasticsearchOptions() *ElasticsearchOptions {
+	return new(ElasticsearchOptions)
+}
+
+func (o *ElasticsearchOptions) SetIndex(index string) *ElasticsearchOptions {
+	o.Index = &index
+	return o
+}
+
+func (o *ElasticsearchOptions) GetIndex() string {
+	if o.Index == nil {
+		return defaultIndex
+	}
+	return *o.Index
+}
+
+func (o *ElasticsearchOptions) SetDateLayout(layout string) *ElasticsearchOptions {
+	o.DateLayout = &layout
+	return o
+}
+
+func (o *ElasticsearchOptions) GetDateLayout() string {
+	if o.DateLayout == nil {
+		return defaultDateLayout
+	}
+	return *o.DateLayout
+}
+
+// Validate checks the index name. It returns a *logger.OptionError for the first problem found.
+func (o *ElasticsearchOptions) Validate() error {
+
+	index := o.GetIndex()
+	if index == "" || index != strings.ToLower(index) || strings.ContainsAny(index, ` "*\<|,>/?#:`) || strings.HasPrefix(index, "_") {
+		return &logger.OptionError{Option: "Index", Reason: "must be a lowercase index name"}
+	}
+
+	if o.GetDateLayout() == "" {
+		return &logger.OptionError{Option: "DateLayout", Reason: "must not be empty"}
+	}
+
+	return nil
+}
+
+// Elasticsearch writes batches of entries with the bulk API of Elasticsearch or OpenSearch
+type Elasticsearch struct {
+	*batcher
+	url        string
+	index      string
+	dateLayout string
+}
+
+// NewElasticsearch creates a sink which writes to the cluster at url ie https://es:9200. Set the Authorization
+// header in the options ie ApiKey or Basic. The format option is not used. Either options may be nil.
+// Use it with the logger's ECS option for entries in the Elastic Common Schema.
+func NewElasticsearch(url string, eo *ElasticsearchOptions, o *Options) (*Elasticsearch, error) {
+
+	if eo == nil {
+		eo = NewElasticsearchOptions()
+	}
+	if o == nil {
+		o = NewOptions()
+	}
+	if err := eo.Validate(); err != nil {
+		return nil, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	e := &Elasticsearch{
+		url:        strings.TrimSuffix(url, "/") + bulkPath,
+		index:      eo.GetIndex(),
+		dateLayout: eo.GetDateLayout(),
+	}
+
+	var err error
+	if e.batcher, err = newBatcher(o.clone(), e.send); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// bulkAction is the action line before each entry. Create works for indexes and data streams.
+type bulkAction struct {
+	Create struct {
+		Index string `json:"_index"`
+	} `json:"create"`
+}
+
+// bulkResponse is the part of the bulk response kept by bulkPath
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// BulkError is returned when some entries of a bulk request failed. Entries the cluster rejected
+// such as ones which do not match the mapping are reported to OnError and only the others are sent again.
+type BulkError struct {
+	// Failed is the number of entries which failed
+	Failed int
+
+	// Reason is the error of the first failed entry
+	Reason string
+
+	remaining [][]byte
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%d entries failed: %s", e.Failed, e.Reason)
+}
+
+// Remaining gets the entries to send again
+func (e *BulkError) Remaining() [][]byte {
+	return e.remaining
+}
+
+func (e *Elasticsearch) send(ctx context.Context, entries [][]byte) error {
+
+	var body bytes.Buffer
+	for _, line := range entries {
+
+		entry, ok := logfile.Parse(line)
+
+		var action bulkAction
+		action.Create.Index = e.index + "-" + entryTime(entry).UTC().Format(e.dateLayout)
+		b, _ := json.Marshal(action)
+		body.Write(b)
+		body.WriteByte('\n')
+
+		// A line which is not JSON is sent as the message
+		if !ok {
+			line, _ = json.Marshal(map[string]string{"message": string(line)})
+		}
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	respBody, err := post(ctx, e.o, e.url, "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var resp bulkResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+
+	retry := &BulkError{}
+	rejected := &BulkError{}
+	for i, item := range resp.Items {
+		for _, result := range item {
+
+			if result.Error == nil || i >= len(entries) {
+				continue
+			}
+
+			failed := rejected
+			if result.Status == http.StatusTooManyRequests || result.Status >= 500 {
+				failed = retry
+				failed.remaining = append(failed.remaining, entries[i])
+			}
+			if failed.Failed == 0 {
+				failed.Reason = result.Error.Type + ": " + result.Error.Reason
+			}
+			failed.Failed++
+		}
+	}
+
+	if rejected.Failed > 0 {
+		e.report(&BatchError{Entries: rejected.Failed, Attempts: 1, Err: rejected})
+	}
+	if retry.Failed > 0 {
+		return retry
+	}
+	return nil
+}
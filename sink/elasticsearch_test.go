@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/realugbun/logger"
+)
+
+func Test_Elasticsearch(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		requests [][]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var lines []string
+		sc := bufio.NewScanner(zr)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, lines)
+
+		// The first request has one entry to retry and one rejected by the mapping
+		if len(requests) == 1 {
+			w.Write([]byte(`{"errors":true,"items":[
+				{"create":{"status":201}},
+				{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}},
+				{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [user]"}}}
+			]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false}`))
+	}))
+	defer srv.Close()
+
+	var errs errorRecorder
+	o := NewOptions().SetFlushInterval(time.Hour).SetMinBackoff(time.Millisecond).SetOnError(errs.onError)
+	e, err := NewElasticsearch(srv.URL+"/", NewElasticsearchOptions().SetIndex("app-logs"), o)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	lines := []string{
+		`{"@timestamp":"2022-03-04T23:59:59Z","message":"started"}`,
+		`{"time":"2022-03-05T00:00:01+01:00","msg":"upload failed"}`,
+		`{"time":"2022-03-05T00:00:02Z","msg":"bad","user":{"id":1}}`,
+	}
+	for _, line := range lines {
+		e.Write([]byte(line + "\n"))
+	}
+	assert.NoError(t, e.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !assert.Len(t, requests, 2) {
+		return
+	}
+
+	// Indexes are named from the time of each entry in UTC
+	assert.Equal(t, []string{
+		`{"create":{"_index":"app-logs-2022.03.04"}}`, lines[0],
+		`{"create":{"_index":"app-logs-2022.03.04"}}`, lines[1],
+		`{"create":{"_index":"app-logs-2022.03.05"}}`, lines[2],
+	}, requests[0])
+
+	// Only the entry which can succeed is sent again
+	assert.Equal(t, []string{`{"create":{"_index":"app-logs-2022.03.04"}}`, lines[1]}, requests[1])
+
+	var be *BatchError
+	if assert.Len(t, errs.get(), 1) && assert.True(t, errors.As(errs.get()[0], &be)) {
+		assert.Equal(t, 1, be.Entries)
+		assert.Contains(t, be.Error(), "mapper_parsing_exception: failed to parse field [user]")
+	}
+}
+
+func Test_ElasticsearchOptions(t *testing.T) {
+
+	for _, tc := range []struct {
+		options   *ElasticsearchOptions
+		expOption string
+	}{
+		{options: NewElasticsearchOptions()},
+		{options: NewElasticsearchOptions().SetIndex("Logs"), expOption: "Index"},
+		{options: NewElasticsearchOptions().SetIndex("logs/app"), expOption: "Index"},
+		{options: NewElasticsearchOptions().SetDateLayout(""), expOption: "DateLayout"},
+	} {
+		err := tc.options.Validate()
+		if tc.expOption == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		if e, ok := err.(*logger.OptionError); assert.True(t, ok, tc.expOption) {
+			assert.Equal(t, tc.expOption, e.Option)
+		}
+	}
+}
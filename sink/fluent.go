@@ -0,0 +1,183 @@
+package sink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/realugbun/logger"
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+const defaultTag = "app"
+
+// FluentOptions choose the tag of the entries and whether the server acknowledges each batch
+type FluentOptions struct {
+
+	// Tag routes the entries in Fluentd or Fluent Bit ie app.api. Defaults to app.
+	Tag *string
+
+	// RequireAck waits for the server to acknowledge each batch so batches lost with the connection are
+	// sent again. Set require_ack_response in Fluentd's forward output or the forward input of Fluent Bit
+	// to match. Defaults to false.
+	RequireAck *bool
+}
+
+func NewFluentOptions() *FluentOptions {
+	return new(FluentOptions)
+}
+
+func (o *FluentOptions) SetTag(tag string) *FluentOptions {
+	o.Tag = &tag
+	return o
+}
+
+func (o *FluentOptions) GetTag() string {
+	if o.Tag == nil {
+		return defaultTag
+	}
+	return *o.Tag
+}
+
+func (o *FluentOptions) SetRequireAck(require bool) *FluentOptions {
+	o.RequireAck = &require
+	return o
+}
+
+func (o *FluentOptions) GetRequireAck() bool {
+	if o.RequireAck == nil {
+		return false
+	}
+	return *o.RequireAck
+}
+
+// Validate checks the tag. It returns a *logger.OptionError for the first problem found.
+func (o *FluentOptions) Validate() error {
+
+	tag := o.GetTag()
+	if tag == "" || strings.ContainsAny(tag, " \t\r\n") {
+		return &logger.OptionError{Option: "Tag", Reason: "must not be empty or contain spaces"}
+	}
+
+	return nil
+}
+
+// Fluent sends entries to Fluentd or Fluent Bit with the Forward protocol
+type Fluent struct {
+	*batcher
+	conn       netConn
+	tag        string
+	requireAck bool
+}
+
+// NewFluent creates a sink which sends to the forward input at addr ie fluent-bit:24224. Each entry is sent
+// as a record with its fields, including the caller fields, as keys and its time as an EventTime. A line which
+// is not JSON is sent as the message key. Only the batching, retry, spool, and OnError options are used.
+// Either options may be nil.
+func NewFluent(addr string, fo *FluentOptions, o *Options) (*Fluent, error) {
+
+	if fo == nil {
+		fo = NewFluentOptions()
+	}
+	if o == nil {
+		o = NewOptions()
+	}
+	if err := fo.Validate(); err != nil {
+		return nil, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	f := &Fluent{
+		conn:       netConn{network: "tcp", addr: addr},
+		tag:        fo.GetTag(),
+		requireAck: fo.GetRequireAck(),
+	}
+
+	var err error
+	if f.batcher, err = newBatcher(o.clone(), f.send); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close sends the remaining entries then closes the connection
+func (f *Fluent) Close() error {
+	err := f.batcher.Close()
+	f.conn.close()
+	return err
+}
+
+func (f *Fluent) send(ctx context.Context, entries [][]byte) error {
+
+	conn, err := f.conn.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer f.conn.interrupt(ctx)()
+
+	var chunk string
+	if f.requireAck {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		chunk = base64.StdEncoding.EncodeToString(id)
+	}
+
+	if _, err := conn.Write(f.message(entries, chunk)); err != nil {
+		f.conn.close()
+		return err
+	}
+
+	if chunk == "" {
+		return nil
+	}
+
+	resp, err := msgpackReader{r: conn, limit: maxAckLength}.read()
+	if err != nil {
+		f.conn.close()
+		return fmt.Errorf("reading ack: %w", err)
+	}
+	if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunk {
+		f.conn.close()
+		return fmt.Errorf("unexpected ack %v", resp)
+	}
+
+	return nil
+}
+
+// message encodes a batch in the Forward mode of the protocol ie [tag, [[time, record], ...], options]
+func (f *Fluent) message(entries [][]byte, chunk string) []byte {
+
+	b := appendArrayHeader(nil, 3)
+	b = appendString(b, f.tag)
+
+	b = appendArrayHeader(b, len(entries))
+	for _, line := range entries {
+
+		entry, ok := logfile.Parse(line)
+		if !ok {
+			entry = logfile.Entry{"message": string(line)}
+		}
+
+		b = appendArrayHeader(b, 2)
+		b = appendEventTime(b, entryTime(entry))
+		b = appendValue(b, map[string]interface{}(entry))
+	}
+
+	if chunk == "" {
+		b = appendMapHeader(b, 1)
+	} else {
+		b = appendMapHeader(b, 2)
+		b = appendString(b, "chunk")
+		b = appendString(b, chunk)
+	}
+	b = appendString(b, "size")
+	b = appendInt(b, int64(len(entries)))
+
+	return b
+}
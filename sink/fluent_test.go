@@ -0,0 +1,201 @@
+package sink
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/realugbun/logger"
+)
+
+// fluentServer decodes Forward messages and acknowledges them, skipping the first ack when dropAck is set
+type fluentServer struct {
+	net.Listener
+
+	mu       sync.Mutex
+	dropAck  bool
+	messages chan []interface{}
+}
+
+func newFluentServer(t *testing.T, dropAck bool) *fluentServer {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fluentServer{Listener: ln, dropAck: dropAck, messages: make(chan []interface{}, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fluentServer) serve(conn net.Conn) {
+
+	defer conn.Close()
+
+	for {
+		v, err := msgpackReader{r: conn, limit: 1 << 20}.read()
+		if err != nil {
+			return
+		}
+		msg, _ := v.([]interface{})
+		s.messages <- msg
+
+		options, _ := msg[2].(map[string]interface{})
+		if chunk, ok := options["chunk"].(string); ok {
+			s.mu.Lock()
+			drop := s.dropAck
+			s.dropAck = false
+			s.mu.Unlock()
+			if drop {
+				return
+			}
+			conn.Write(appendString(appendString(appendMapHeader(nil, 1), "ack"), chunk))
+		}
+	}
+}
+
+func Test_Fluent(t *testing.T) {
+
+	s := newFluentServer(t, false)
+	defer s.Close()
+
+	f, err := NewFluent(s.Addr().String(), NewFluentOptions().SetTag("app.api"), NewOptions().SetFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	f.Write([]byte(`{"level":"error","msg":"upload failed","time":"2022-03-04T15:04:05.25Z","file":"/app/upload.go","line":42,"ok":false,"user":{"id":7,"roles":["admin"]}}` + "\n"))
+	f.Write([]byte("plain text\n"))
+	assert.NoError(t, f.Close())
+
+	msg := <-s.messages
+	assert.Equal(t, []interface{}{
+		"app.api",
+		[]interface{}{
+			[]interface{}{
+				time.Unix(1646406245, 250000000),
+				map[string]interface{}{
+					"level": "error",
+					"msg":   "upload failed",
+					"time":  "2022-03-04T15:04:05.25Z",
+					"file":  "/app/upload.go",
+					"line":  int64(42),
+					"ok":    false,
+					"user":  map[string]interface{}{"id": int64(7), "roles": []interface{}{"admin"}},
+				},
+			},
+			[]interface{}{msg[1].([]interface{})[1].([]interface{})[0], map[string]interface{}{"message": "plain text"}},
+		},
+		map[string]interface{}{"size": int64(2)},
+	}, msg)
+}
+
+func Test_FluentAck(t *testing.T) {
+
+	// The first batch is not acknowledged so it is sent again on a new connection
+	s := newFluentServer(t, true)
+	defer s.Close()
+
+	var errs errorRecorder
+	fo := NewFluentOptions().SetRequireAck(true)
+	o := NewOptions().SetFlushInterval(time.Hour).SetMinBackoff(time.Millisecond).SetOnError(errs.onError)
+	f, err := NewFluent(s.Addr().String(), fo, o)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	f.Write([]byte(`{"msg":"started","time":"2022-03-04T15:04:05Z"}` + "\n"))
+	assert.NoError(t, f.Flush())
+	assert.NoError(t, f.Close())
+	assert.Empty(t, errs.get())
+
+	first, second := <-s.messages, <-s.messages
+	assert.Equal(t, first[1], second[1])
+
+	chunk1 := first[2].(map[string]interface{})["chunk"]
+	chunk2 := second[2].(map[string]interface{})["chunk"]
+	assert.NotEmpty(t, chunk1)
+	assert.NotEqual(t, chunk1, chunk2)
+}
+
+func Test_FluentOptions(t *testing.T) {
+
+	for _, tc := range []struct {
+		options   *FluentOptions
+		expOption string
+	}{
+		{options: NewFluentOptions()},
+		{options: NewFluentOptions().SetTag("app.api").SetRequireAck(true)},
+		{options: NewFluentOptions().SetTag(""), expOption: "Tag"},
+		{options: NewFluentOptions().SetTag("app api"), expOption: "Tag"},
+	} {
+		err := tc.options.Validate()
+		if tc.expOption == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		if e, ok := err.(*logger.OptionError); assert.True(t, ok, tc.expOption) {
+			assert.Equal(t, tc.expOption, e.Option)
+		}
+	}
+}
+
+func Test_msgpack(t *testing.T) {
+
+	for _, v := range []interface{}{
+		nil,
+		true,
+		int64(-33),
+		int64(-1),
+		int64(127),
+		int64(1 << 40),
+		1.5,
+		"",
+		string(bytes.Repeat([]byte("a"), 40)),
+		string(bytes.Repeat([]byte("b"), 300)),
+		string(bytes.Repeat([]byte("c"), 70000)),
+		make([]interface{}, 20),
+		map[string]interface{}{"a": int64(1), "b": []interface{}{"c"}},
+		time.Unix(1646406245, 123456789),
+	} {
+		var b []byte
+		if tm, ok := v.(time.Time); ok {
+			b = appendEventTime(nil, tm)
+		} else {
+			b = appendValue(nil, v)
+		}
+
+		got, err := msgpackReader{r: bytes.NewReader(b), limit: 1 << 20}.read()
+		assert.NoError(t, err)
+		assert.Equal(t, v, got)
+	}
+}
+
+func Test_msgpackLimits(t *testing.T) {
+
+	for _, tc := range []struct {
+		name string
+		b    []byte
+	}{
+		{name: "str32", b: []byte{0xdb, 0xff, 0xff, 0xff, 0xff}},
+		{name: "array32", b: []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{name: "map16", b: []byte{0xde, 0x01, 0x01}},
+		{name: "nested", b: bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2)},
+	} {
+		_, err := msgpackReader{r: bytes.NewReader(tc.b), limit: maxAckLength}.read()
+		assert.Equal(t, errMsgpack, err, tc.name)
+	}
+}
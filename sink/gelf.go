@@ -0,0 +1,377 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/realugbun/logger"
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+// Protocols for sending GELF
+const (
+	// GELFUDP sends each entry in its own datagram split into chunks when it is larger than ChunkSize
+	GELFUDP = "udp"
+
+	// GELFTCP sends entries ended by a null byte over a connection. They are not compressed.
+	GELFTCP = "tcp"
+)
+
+const (
+	gelfVersion      = "1.1"
+	defaultChunkSize = 1420
+
+	// gelfChunkHeader is the magic bytes, message id, sequence number, and count starting each chunk
+	gelfChunkHeader = 12
+	gelfMaxChunks   = 128
+
+	// maxDatagram is the largest UDP payload
+	maxDatagram = 65507
+)
+
+var (
+	gelfChunkMagic = []byte{0x1e, 0x0f}
+
+	// invalidGELFField matches the characters which are not allowed in additional field names
+	invalidGELFField = regexp.MustCompile(`[^\w.\-]`)
+
+	errGELFTooLarge = fmt.Errorf("entry needs more than %d chunks", gelfMaxChunks)
+)
+
+// syslogLevels maps the levels to the syslog severities used by GELF
+var syslogLevels = map[string]int{
+	"panic":   1,
+	"fatal":   2,
+	"error":   3,
+	"warning": 4,
+	"info":    6,
+	"debug":   7,
+	"trace":   7,
+}
+
+// Fields read into the GELF fields rather than sent as additional fields. The ECS names are read when the
+// logger's ECS option is set.
+var (
+	gelfMessageKeys = []string{logger.FieldKeyMsg, "message"}
+	gelfLevelKeys   = []string{logger.FieldKeyLevel, "log.level"}
+	gelfHostKeys    = []string{logger.FieldKeyHostname, "host.hostname"}
+	gelfTimeKeys    = []string{logger.FieldKeyTime, ecsTimestamp}
+)
+
+// GELFOptions choose how entries are sent to Graylog
+type GELFOptions struct {
+
+	// Protocol is GELFUDP or GELFTCP. Defaults to GELFUDP.
+	Protocol *string
+
+	// Host is the host field of every message. Defaults to the hostname field of each entry
+	// then the name of this host.
+	Host *string
+
+	// ChunkSize is the largest UDP datagram sent including the chunk header. Defaults to 1420 which fits
+	// most networks. It can be raised to 8192 on a local network.
+	ChunkSize *int
+}
+
+func NewGELFOptions() *GELFOptions {
+	return new(GELFOptions)
+}
+
+func (o *GELFOptions) SetProtocol(protocol string) *GELFOptions {
+	o.Protocol = &protocol
+	return o
+}
+
+func (o *GELFOptions) GetProtocol() string {
+	if o.Protocol == nil {
+		return GELFUDP
+	}
+	return *o.Protocol
+}
+
+func (o *GELFOptions) SetHost(host string) *GELFOptions {
+	o.Host = &host
+	return o
+}
+
+func (o *GELFOptions) GetHost() string {
+	if o.Host == nil {
+		return ""
+	}
+	return *o.Host
+}
+
+func (o *GELFOptions) SetChunkSize(n int) *GELFOptions {
+	o.ChunkSize = &n
+	return o
+}
+
+func (o *GELFOptions) GetChunkSize() int {
+	if o.ChunkSize == nil {
+		return defaultChunkSize
+	}
+	return *o.ChunkSize
+}
+
+// Validate checks the protocol and chunk size. It returns a *logger.OptionError for the first problem found.
+func (o *GELFOptions) Validate() error {
+
+	if p := o.GetProtocol(); p != GELFUDP && p != GELFTCP {
+		return &logger.OptionError{Option: "Protocol", Reason: "must be " + GELFUDP + " or " + GELFTCP}
+	}
+
+	if n := o.GetChunkSize(); n <= gelfChunkHeader || n > maxDatagram {
+		return &logger.OptionError{Option: "ChunkSize", Reason: fmt.Sprintf("must be between %d and %d", gelfChunkHeader+1, maxDatagram)}
+	}
+
+	return nil
+}
+
+// GELF sends entries to Graylog in the Graylog Extended Log Format
+type GELF struct {
+	*batcher
+	conn      netConn
+	host      string
+	chunkSize int
+}
+
+// NewGELF creates a sink which sends to the GELF input at addr ie graylog:12201. The caller and custom fields
+// are sent as additional fields with nested fields joined by underscores. UDP messages are compressed when the
+// gzip option is set. The format, header, and client options are not used. Either options may be nil.
+func NewGELF(addr string, gelfOpts *GELFOptions, o *Options) (*GELF, error) {
+
+	if gelfOpts == nil {
+		gelfOpts = NewGELFOptions()
+	}
+	if o == nil {
+		o = NewOptions()
+	}
+	if err := gelfOpts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	g := &GELF{
+		conn:      netConn{network: gelfOpts.GetProtocol(), addr: addr},
+		host:      gelfOpts.GetHost(),
+		chunkSize: gelfOpts.GetChunkSize(),
+	}
+	if g.host == "" {
+		g.host, _ = os.Hostname()
+	}
+
+	var err error
+	if g.batcher, err = newBatcher(o.clone(), g.send); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Close sends the remaining entries then closes the connection
+func (g *GELF) Close() error {
+	err := g.batcher.Close()
+	g.conn.close()
+	return err
+}
+
+func (g *GELF) send(ctx context.Context, entries [][]byte) error {
+
+	conn, err := g.conn.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer g.conn.interrupt(ctx)()
+
+	if g.conn.network == GELFTCP {
+		var buf bytes.Buffer
+		for _, e := range entries {
+			buf.Write(g.message(e))
+			buf.WriteByte(0)
+		}
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			g.conn.close()
+			return err
+		}
+		return nil
+	}
+
+	for i, e := range entries {
+
+		chunks, err := g.chunks(g.message(e))
+		if err != nil {
+			// Sending it again will not help so only this entry is dropped
+			g.report(&BatchError{Entries: 1, Attempts: 1, Err: err})
+			continue
+		}
+
+		for _, c := range chunks {
+			if _, err := conn.Write(c); err != nil {
+				g.conn.close()
+				return &remainingError{err: err, remaining: entries[i:]}
+			}
+		}
+	}
+
+	return nil
+}
+
+// message encodes an entry as GELF. A line which is not JSON is sent as the short message.
+func (g *GELF) message(line []byte) []byte {
+
+	entry, ok := logfile.Parse(line)
+	if !ok {
+		entry = logfile.Entry{}
+	}
+
+	msg := map[string]interface{}{
+		"version": gelfVersion,
+		"host":    g.host,
+		"level":   6,
+	}
+	used := make(map[string]bool)
+
+	// GELF needs a short message so an entry without one is sent in full
+	k, short := firstField(entry, gelfMessageKeys)
+	used[k] = true
+	if short == "" {
+		short = string(line)
+	}
+	msg["short_message"] = short
+
+	if k, v := firstField(entry, gelfLevelKeys); k != "" {
+		used[k] = true
+		if level, ok := syslogLevels[v]; ok {
+			msg["level"] = level
+		}
+	}
+
+	if k, v := firstField(entry, gelfHostKeys); k != "" {
+		used[k] = true
+		if v != "" {
+			msg["host"] = v
+		}
+	}
+
+	for _, k := range gelfTimeKeys {
+		used[k] = true
+	}
+	msg["timestamp"] = float64(entryTime(entry).UnixNano()/int64(time.Microsecond)) / 1e6
+
+	// A trace in the panic format reads best as the full message. Traces in the other formats are sent
+	// as additional fields.
+	if trace, ok := entry[logger.FieldKeyTrace].(string); ok {
+		used[logger.FieldKeyTrace] = true
+		msg["full_message"] = trace
+	}
+
+	for k, v := range entry {
+		if !used[k] {
+			addGELFField(msg, k, v)
+		}
+	}
+
+	b, _ := json.Marshal(msg)
+	return b
+}
+
+// firstField gets the first of keys the entry has
+func firstField(entry logfile.Entry, keys []string) (string, string) {
+	for _, k := range keys {
+		if _, ok := entry[k]; ok {
+			return k, entry.String(k)
+		}
+	}
+	return "", ""
+}
+
+// addGELFField adds a field as an additional field. Objects are flattened with their keys joined by
+// underscores ie caller.file becomes _caller_file. GELF only has strings and numbers so other values
+// are sent as JSON.
+func addGELFField(msg map[string]interface{}, key string, v interface{}) {
+
+	if m, ok := v.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			addGELFField(msg, key+"_"+k, m[k])
+		}
+		return
+	}
+
+	name := "_" + invalidGELFField.ReplaceAllString(key, "_")
+
+	// _id is reserved by Graylog
+	if name == "_id" {
+		name = "_id_"
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return
+	case string, json.Number:
+		msg[name] = v
+	default:
+		b, _ := json.Marshal(v)
+		msg[name] = string(b)
+	}
+}
+
+// chunks compresses a message when the gzip option is set and splits it into chunks when it is larger than
+// a datagram
+func (g *GELF) chunks(msg []byte) ([][]byte, error) {
+
+	if g.o.GetGzip() {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(msg)
+		zw.Close()
+		msg = buf.Bytes()
+	}
+
+	if len(msg) <= g.chunkSize {
+		return [][]byte{msg}, nil
+	}
+
+	size := g.chunkSize - gelfChunkHeader
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, errGELFTooLarge
+	}
+
+	// Message ids must be unique across every process sending to the input
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		c := make([]byte, 0, gelfChunkHeader+end-i*size)
+		c = append(c, gelfChunkMagic...)
+		c = append(c, id...)
+		c = append(c, byte(i), byte(count))
+		c = append(c, msg[i*size:end]...)
+		chunks = append(chunks, c)
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,214 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/realugbun/logger"
+)
+
+// readGELFUDP reads datagrams until n messages are complete, joining chunks and decompressing
+func readGELFUDP(t *testing.T, pc net.PacketConn, n int) []map[string]interface{} {
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var msgs []map[string]interface{}
+	chunks := make(map[string][][]byte)
+
+	buf := make([]byte, maxDatagram)
+	for len(msgs) < n {
+		size, _, err := pc.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			return msgs
+		}
+		d := append([]byte(nil), buf[:size]...)
+
+		if bytes.HasPrefix(d, gelfChunkMagic) {
+			id := string(d[2:10])
+			if chunks[id] == nil {
+				chunks[id] = make([][]byte, d[11])
+			}
+			chunks[id][d[10]] = d[gelfChunkHeader:]
+
+			complete := true
+			for _, c := range chunks[id] {
+				complete = complete && c != nil
+			}
+			if !complete {
+				continue
+			}
+			d = bytes.Join(chunks[id], nil)
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(d))
+		if !assert.NoError(t, err) {
+			return msgs
+		}
+		var msg map[string]interface{}
+		assert.NoError(t, json.NewDecoder(zr).Decode(&msg))
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func Test_GELFUDP(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pc.Close()
+
+	g, err := NewGELF(pc.LocalAddr().String(), NewGELFOptions().SetChunkSize(50).SetHost("api-1"), NewOptions().SetFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Both entries are larger than a chunk when compressed so they are reassembled
+	g.Write([]byte(`{"level":"error","msg":"upload failed","time":"2022-03-04T15:04:05.25Z","file":"/app/upload.go","line":42,"func":"main.upload","user":{"id":7}}` + "\n"))
+	g.Write([]byte(`{"level":"panic","msg":"crashed","time":"2022-03-04T15:04:06Z","trace":"goroutine 1 [running]:\n` + strings.Repeat(`main.f()\n\t/app/main.go:10 +0x1d\n`, 20) + `"}` + "\n"))
+	assert.NoError(t, g.Close())
+
+	msgs := readGELFUDP(t, pc, 2)
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"version":       "1.1",
+		"host":          "api-1",
+		"short_message": "upload failed",
+		"timestamp":     1646406245.25,
+		"level":         float64(3),
+		"_file":         "/app/upload.go",
+		"_line":         float64(42),
+		"_func":         "main.upload",
+		"_user_id":      float64(7),
+	}, msgs[0])
+
+	assert.Equal(t, "crashed", msgs[1]["short_message"])
+	assert.Equal(t, float64(1), msgs[1]["level"])
+	assert.True(t, strings.HasPrefix(msgs[1]["full_message"].(string), "goroutine 1 [running]:\n"))
+	assert.Nil(t, msgs[1]["_trace"])
+}
+
+func Test_GELFTCP(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+
+		var msgs []string
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := r.ReadString(0)
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, strings.TrimSuffix(msg, "\x00"))
+		}
+		received <- msgs
+	}()
+
+	g, err := NewGELF(ln.Addr().String(), NewGELFOptions().SetProtocol(GELFTCP), NewOptions().SetFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	g.Write([]byte(`{"level":"info","msg":"started","time":"2022-03-04T15:04:05Z","hostname":"api-2","id":"abc","caller":{"file":"/app/main.go","line":10}}` + "\n"))
+	g.Write([]byte("plain text\n"))
+	assert.NoError(t, g.Flush())
+	assert.NoError(t, g.Close())
+
+	msgs := <-received
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+
+	var first map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(msgs[0]), &first))
+	assert.Equal(t, "api-2", first["host"])
+	assert.Equal(t, float64(6), first["level"])
+	assert.Equal(t, "abc", first["_id_"])
+	assert.Equal(t, "/app/main.go", first["_caller_file"])
+	assert.Equal(t, float64(10), first["_caller_line"])
+	assert.Nil(t, first["_hostname"])
+
+	var second map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(msgs[1]), &second))
+	assert.Equal(t, "plain text", second["short_message"])
+}
+
+func Test_GELFTooLarge(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pc.Close()
+
+	var errs errorRecorder
+	g, err := NewGELF(pc.LocalAddr().String(), NewGELFOptions().SetChunkSize(300), NewOptions().SetGzip(false).SetOnError(errs.onError))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Only the entry which needs too many chunks is dropped
+	g.Write([]byte(`{"msg":"` + strings.Repeat("x", 300*gelfMaxChunks) + `"}` + "\n"))
+	g.Write([]byte(`{"msg":"ok"}` + "\n"))
+	assert.NoError(t, g.Close())
+
+	if e := errs.get(); assert.Len(t, e, 1) {
+		assert.Equal(t, &BatchError{Entries: 1, Attempts: 1, Err: errGELFTooLarge}, e[0])
+	}
+
+	buf := make([]byte, maxDatagram)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(buf[:n]), `"short_message":"ok"`)
+	}
+}
+
+func Test_GELFOptions(t *testing.T) {
+
+	for _, tc := range []struct {
+		options   *GELFOptions
+		expOption string
+	}{
+		{options: NewGELFOptions()},
+		{options: NewGELFOptions().SetProtocol(GELFTCP).SetChunkSize(8192)},
+		{options: NewGELFOptions().SetProtocol("http"), expOption: "Protocol"},
+		{options: NewGELFOptions().SetChunkSize(gelfChunkHeader), expOption: "ChunkSize"},
+		{options: NewGELFOptions().SetChunkSize(maxDatagram + 1), expOption: "ChunkSize"},
+	} {
+		err := tc.options.Validate()
+		if tc.expOption == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		if e, ok := err.(*logger.OptionError); assert.True(t, ok, tc.expOption) {
+			assert.Equal(t, tc.expOption, e.Option)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxErrorBody is the most of a response body kept in a StatusError
+const maxErrorBody = 512
+
+// StatusError is returned when the server responds with a status other than 2xx
+type StatusError struct {
+	StatusCode int
+
+	// Body is the start of the response body
+	Body string
+
+	retryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// Permanent is true for client errors other than 408 and 429 which will fail again if the batch is resent
+func (e *StatusError) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// RetryAfter is the wait asked for by the Retry-After header
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// HTTP posts batches of entries to a URL
+type HTTP struct {
+	*batcher
+	url string
+}
+
+// NewHTTP creates a sink which posts batches to url as NDJSON or a JSON array. Options may be nil.
+// Invalid options are returned as a *logger.OptionError.
+func NewHTTP(url string, o *Options) (*HTTP, error) {
+
+	if o == nil {
+		o = NewOptions()
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	o = o.clone()
+
+	h := &HTTP{url: url}
+
+	var err error
+	if h.batcher, err = newBatcher(o, h.send); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *HTTP) send(ctx context.Context, entries [][]byte) error {
+
+	var body []byte
+	contentType := "application/x-ndjson"
+
+	if h.o.GetFormat() == FormatJSONArray {
+		contentType = "application/json"
+		body = append(append([]byte("["), bytes.Join(entries, []byte(","))...), ']')
+	} else {
+		body = append(bytes.Join(entries, []byte("\n")), '\n')
+	}
+
+	_, err := post(ctx, h.o, h.url, contentType, body)
+	return err
+}
+
+// post sends a body, compressing it when Gzip is set. It returns the body of a 2xx response.
+func post(ctx context.Context, o *Options, url, contentType string, body []byte) ([]byte, error) {
+
+	if o.GetGzip() {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range o.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	if o.GetGzip() {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := o.GetClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return io.ReadAll(resp.Body)
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(b))}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		e.retryAfter = time.Duration(s) * time.Second
+	}
+	return nil, e
+}
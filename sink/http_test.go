@@ -0,0 +1,325 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/realugbun/logger"
+)
+
+// testServer records the entries of each request and responds with the next status
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	batches  [][]string
+}
+
+func newTestServer(statuses ...int) *testServer {
+
+	s := &testServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+
+		var entries []string
+		if r.Header.Get("Content-Type") == "application/json" {
+			var array []json.RawMessage
+			json.NewDecoder(body).Decode(&array)
+			for _, e := range array {
+				entries = append(entries, string(e))
+			}
+		} else {
+			sc := bufio.NewScanner(body)
+			for sc.Scan() {
+				entries = append(entries, sc.Text())
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.requests = append(s.requests, r)
+		if status == http.StatusOK {
+			s.batches = append(s.batches, entries)
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *testServer) received() ([]*http.Request, [][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.batches
+}
+
+// errorRecorder collects the errors passed to OnError
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *errorRecorder) onError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *errorRecorder) get() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errs
+}
+
+func Test_HTTP(t *testing.T) {
+
+	for _, tc := range []struct {
+		name        string
+		options     *Options
+		statuses    []int
+		expBatches  [][]string
+		expRequests int
+		expAttempts int
+	}{
+		{
+			name:        "batches by count",
+			options:     NewOptions().SetMaxEntries(2),
+			expBatches:  [][]string{{`{"n":1}`, `{"n":2}`}, {`{"n":3}`, `{"n":4}`}, {`{"n":5}`}},
+			expRequests: 3,
+		},
+		{
+			name:        "batches by bytes",
+			options:     NewOptions().SetMaxBytes(15).SetFormat(FormatJSONArray).SetGzip(false),
+			expBatches:  [][]string{{`{"n":1}`, `{"n":2}`}, {`{"n":3}`, `{"n":4}`}, {`{"n":5}`}},
+			expRequests: 3,
+		},
+		{
+			name:        "retried",
+			options:     NewOptions().SetMinBackoff(time.Millisecond),
+			statuses:    []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			expBatches:  [][]string{{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`}},
+			expRequests: 3,
+		},
+		{
+			name:        "retries used up",
+			options:     NewOptions().SetMinBackoff(time.Millisecond).SetMaxRetries(2),
+			statuses:    []int{500, 500, 500},
+			expRequests: 3,
+			expAttempts: 3,
+		},
+		{
+			name:        "not retried",
+			options:     NewOptions().SetMinBackoff(time.Millisecond),
+			statuses:    []int{http.StatusBadRequest},
+			expRequests: 1,
+			expAttempts: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			srv := newTestServer(tc.statuses...)
+			defer srv.Close()
+
+			var errs errorRecorder
+			h, err := NewHTTP(srv.URL, tc.options.SetFlushInterval(time.Hour).SetHeader("Authorization", "Bearer token").SetOnError(errs.onError))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			for _, e := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`} {
+				h.Write([]byte(e + "\n"))
+			}
+			assert.NoError(t, h.Close())
+
+			_, err = h.Write([]byte(`{"n":6}`))
+			assert.Equal(t, ErrClosed, err)
+
+			requests, batches := srv.received()
+			assert.Equal(t, tc.expBatches, batches)
+			if assert.Len(t, requests, tc.expRequests) {
+				assert.Equal(t, "Bearer token", requests[0].Header.Get("Authorization"))
+			}
+
+			if tc.expAttempts == 0 {
+				assert.Empty(t, errs.get())
+				return
+			}
+
+			var be *BatchError
+			if assert.Len(t, errs.get(), 1) && assert.True(t, errors.As(errs.get()[0], &be)) {
+				assert.Equal(t, 5, be.Entries)
+				assert.Equal(t, tc.expAttempts, be.Attempts)
+				assert.IsType(t, &StatusError{}, be.Err)
+			}
+		})
+	}
+}
+
+func Test_batcherFlushInterval(t *testing.T) {
+
+	sent := make(chan [][]byte, 1)
+	b, _ := newBatcher(NewOptions().SetFlushInterval(10*time.Millisecond), func(ctx context.Context, entries [][]byte) error {
+		sent <- entries
+		return nil
+	})
+	defer b.Close()
+
+	b.Write([]byte("{}\n"))
+
+	select {
+	case entries := <-sent:
+		assert.Equal(t, [][]byte{[]byte("{}")}, entries)
+	case <-time.After(5 * time.Second):
+		t.Error("batch not sent after the flush interval")
+	}
+}
+
+func Test_batcherMaxPending(t *testing.T) {
+
+	var errs errorRecorder
+	release := make(chan struct{})
+	b, _ := newBatcher(NewOptions().SetMaxEntries(1).SetMaxPending(2).SetFlushInterval(time.Hour).SetOnError(errs.onError),
+		func(ctx context.Context, entries [][]byte) error {
+			<-release
+			return nil
+		})
+
+	for i := 0; i < 5; i++ {
+		b.Write([]byte("{}\n"))
+	}
+	close(release)
+	b.Close()
+
+	// The first entry may be sent before the others are written
+	dropped := 0
+	for _, err := range errs.get() {
+		var be *BatchError
+		if assert.True(t, errors.As(err, &be)) {
+			assert.Equal(t, 0, be.Attempts)
+			dropped += be.Entries
+		}
+	}
+	assert.True(t, dropped == 2 || dropped == 3, dropped)
+}
+
+func Test_batcherCloseTimeout(t *testing.T) {
+
+	var errs errorRecorder
+	sending := make(chan struct{}, 1)
+	o := NewOptions().SetFlushInterval(10 * time.Millisecond).SetMaxRetries(100).SetMinBackoff(time.Second).
+		SetCloseTimeout(100 * time.Millisecond).SetOnError(errs.onError)
+	b, _ := newBatcher(o, func(ctx context.Context, entries [][]byte) error {
+		select {
+		case sending <- struct{}{}:
+		default:
+		}
+		return errors.New("unavailable")
+	})
+
+	b.Write([]byte("{}\n"))
+	<-sending
+
+	// Close does not wait for the retries of a batch sent before it was called
+	start := time.Now()
+	b.Close()
+	assert.True(t, time.Since(start) < 2*time.Second, time.Since(start))
+
+	if e := errs.get(); assert.Len(t, e, 1) {
+		var be *BatchError
+		if assert.True(t, errors.As(e[0], &be)) {
+			assert.Equal(t, 1, be.Entries)
+		}
+	}
+}
+
+func Test_backoff(t *testing.T) {
+
+	b := &batcher{o: NewOptions().SetMinBackoff(100 * time.Millisecond).SetMaxBackoff(time.Second)}
+
+	for _, tc := range []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 100 * time.Millisecond},
+		{attempt: 2, max: 200 * time.Millisecond},
+		{attempt: 4, max: 800 * time.Millisecond},
+		{attempt: 10, max: time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			d := b.backoff(tc.attempt)
+			assert.True(t, d >= tc.max/2 && d <= tc.max, "attempt %d waited %v", tc.attempt, d)
+		}
+	}
+}
+
+func Test_Validate(t *testing.T) {
+
+	for _, tc := range []struct {
+		options   *Options
+		expOption string
+	}{
+		{options: NewOptions()},
+		{options: NewOptions().SetMaxEntries(0), expOption: "MaxEntries"},
+		{options: NewOptions().SetMaxPending(10), expOption: "MaxPending"},
+		{options: NewOptions().SetMinBackoff(time.Second).SetMaxBackoff(time.Millisecond), expOption: "MaxBackoff"},
+		{options: NewOptions().SetFlushInterval(0), expOption: "FlushInterval"},
+		{options: NewOptions().SetFormat("xml"), expOption: "Format"},
+	} {
+		err := tc.options.Validate()
+		if tc.expOption == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		if e, ok := err.(*logger.OptionError); assert.True(t, ok, tc.expOption) {
+			assert.Equal(t, tc.expOption, e.Option)
+		}
+	}
+}
+
+func Test_logger(t *testing.T) {
+
+	srv := newTestServer()
+	defer srv.Close()
+
+	h, _ := NewHTTP(srv.URL, nil)
+	logger.InitWithOptions(logger.NewOptions().SetLevel("info").AddSink(h))
+	logger.InfoWithFields(logger.Fields{"user": "b7ad6b71"}, "user deleted")
+	assert.NoError(t, logger.Close())
+
+	_, batches := srv.received()
+	if assert.Len(t, batches, 1) && assert.Len(t, batches[0], 2) {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(batches[0][1]), &entry))
+		assert.Equal(t, "user deleted", entry["msg"])
+		assert.Equal(t, "b7ad6b71", entry["user"])
+	}
+}
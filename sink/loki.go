@@ -0,0 +1,209 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/realugbun/logger"
+	"github.com/realugbun/logger/internal/logfile"
+)
+
+// lokiPushPath is added to the Loki URL when it is not already there
+const lokiPushPath = "/loki/api/v1/push"
+
+var (
+	labelName    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	invalidLabel = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// LokiOptions choose the labels of the streams entries are pushed to
+type LokiOptions struct {
+
+	// Labels are added to every stream ie job=api
+	Labels map[string]string
+
+	// LabelFields are entry fields whose values are added as labels ie level or service. Dotted names read
+	// nested fields and characters which are not allowed in label names are replaced with underscores.
+	// Fields with many values such as file and line should be left in the line rather than made labels.
+	LabelFields []string
+}
+
+func NewLokiOptions() *LokiOptions {
+	return new(LokiOptions)
+}
+
+// SetLabel adds a label to every stream
+func (o *LokiOptions) SetLabel(name, value string) *LokiOptions {
+	if o.Labels == nil {
+		o.Labels = make(map[string]string)
+	}
+	o.Labels[name] = value
+	return o
+}
+
+// AddLabelField adds an entry field as a label
+func (o *LokiOptions) AddLabelField(field string) *LokiOptions {
+	o.LabelFields = append(o.LabelFields, field)
+	return o
+}
+
+// Validate checks the label names. It returns a *logger.OptionError for the first problem found.
+func (o *LokiOptions) Validate() error {
+
+	for name := range o.Labels {
+		if !labelName.MatchString(name) {
+			return &logger.OptionError{Option: "Labels", Reason: "invalid label name " + strconv.Quote(name)}
+		}
+	}
+
+	for _, field := range o.LabelFields {
+		if field == "" {
+			return &logger.OptionError{Option: "LabelFields", Reason: "field name is empty"}
+		}
+	}
+
+	return nil
+}
+
+// Loki pushes batches of entries to the Loki push API
+type Loki struct {
+	*batcher
+	url    string
+	labels map[string]string
+	fields []string
+}
+
+// NewLoki creates a sink which pushes to Loki at url ie http://loki:3100. Set the X-Scope-OrgID header
+// in the options for a multi-tenant Loki. The format option is not used. Either options may be nil.
+func NewLoki(url string, lo *LokiOptions, o *Options) (*Loki, error) {
+
+	if lo == nil {
+		lo = NewLokiOptions()
+	}
+	if o == nil {
+		o = NewOptions()
+	}
+	if err := lo.Validate(); err != nil {
+		return nil, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(url, lokiPushPath) {
+		url = strings.TrimSuffix(url, "/") + lokiPushPath
+	}
+
+	l := &Loki{
+		url:    url,
+		labels: make(map[string]string, len(lo.Labels)),
+		fields: append([]string(nil), lo.LabelFields...),
+	}
+	for k, v := range lo.Labels {
+		l.labels[k] = v
+	}
+
+	var err error
+	if l.batcher, err = newBatcher(o.clone(), l.send); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// lokiStream is a stream of the push request
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (l *Loki) send(ctx context.Context, entries [][]byte) error {
+
+	var streams []*lokiStream
+	byLabels := make(map[string]*lokiStream)
+
+	for _, e := range entries {
+
+		// Lines which are not JSON are sent with the static labels and the current time
+		entry, _ := logfile.Parse(e)
+
+		labels := l.streamLabels(entry)
+		key := labelKey(labels)
+
+		s := byLabels[key]
+		if s == nil {
+			s = &lokiStream{Stream: labels}
+			byLabels[key] = s
+			streams = append(streams, s)
+		}
+
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(entryTime(entry).UnixNano(), 10), string(e)})
+	}
+
+	body, err := json.Marshal(map[string][]*lokiStream{"streams": streams})
+	if err != nil {
+		return err
+	}
+
+	_, err = post(ctx, l.o, l.url, "application/json", body)
+	return err
+}
+
+// streamLabels gets the labels for an entry from the static labels and the label fields
+func (l *Loki) streamLabels(entry logfile.Entry) map[string]string {
+
+	labels := make(map[string]string, len(l.labels)+len(l.fields))
+	for k, v := range l.labels {
+		labels[k] = v
+	}
+
+	for _, f := range l.fields {
+		if v := entry.String(f); v != "" {
+			labels[labelFor(f)] = v
+		}
+	}
+	return labels
+}
+
+// labelFor makes a field name a valid label name ie kubernetes.namespace becomes kubernetes_namespace
+func labelFor(field string) string {
+	name := invalidLabel.ReplaceAllString(field, "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// labelKey identifies a set of labels
+func labelKey(labels map[string]string) string {
+
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, k := range names {
+		sb.WriteString(strconv.Quote(k) + "=" + strconv.Quote(labels[k]) + ",")
+	}
+	return sb.String()
+}
+
+// entryTime gets the time of an entry, read from @timestamp when it is written with the ECS option,
+// or the current time if it has none
+func entryTime(entry logfile.Entry) time.Time {
+	if t, ok := entry.Time(); ok {
+		return t
+	}
+	if s, ok := entry[ecsTimestamp].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
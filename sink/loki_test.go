@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Loki(t *testing.T) {
+
+	var (
+		mu      sync.Mutex
+		path    string
+		tenant  string
+		streams []lokiStream
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var push struct {
+			Streams []lokiStream
+		}
+		if err := json.NewDecoder(zr).Decode(&push); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		path, tenant = r.URL.Path, r.Header.Get("X-Scope-OrgID")
+		streams = append(streams, push.Streams...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	lo := NewLokiOptions().SetLabel("job", "api").AddLabelField("level").AddLabelField("kubernetes.namespace")
+	l, err := NewLoki(srv.URL+"/", lo, NewOptions().SetHeader("X-Scope-OrgID", "team-a").SetFlushInterval(time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	lines := []string{
+		`{"level":"info","msg":"started","time":"2022-03-04T15:04:05Z","file":"/app/main.go","line":10,"kubernetes":{"namespace":"prod"}}`,
+		`{"level":"error","msg":"upload failed","time":"2022-03-04T15:04:06Z","file":"/app/upload.go","line":42,"kubernetes":{"namespace":"prod"}}`,
+		`{"level":"info","msg":"stopped","time":"2022-03-04T15:04:07Z","kubernetes":{"namespace":"prod"}}`,
+	}
+	for _, line := range lines {
+		l.Write([]byte(line + "\n"))
+	}
+	assert.NoError(t, l.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, lokiPushPath, path)
+	assert.Equal(t, "team-a", tenant)
+
+	// Entries are grouped by their labels in the order they were written
+	assert.Equal(t, []lokiStream{
+		{
+			Stream: map[string]string{"job": "api", "level": "info", "kubernetes_namespace": "prod"},
+			Values: [][2]string{{"1646406245000000000", lines[0]}, {"1646406247000000000", lines[2]}},
+		},
+		{
+			Stream: map[string]string{"job": "api", "level": "error", "kubernetes_namespace": "prod"},
+			Values: [][2]string{{"1646406246000000000", lines[1]}},
+		},
+	}, streams)
+}
+
+func Test_LokiOptions(t *testing.T) {
+
+	assert.NoError(t, NewLokiOptions().SetLabel("job", "api").AddLabelField("service").Validate())
+	assert.Error(t, NewLokiOptions().SetLabel("app.name", "api").Validate())
+	assert.Error(t, NewLokiOptions().AddLabelField("").Validate())
+
+	assert.Equal(t, "kubernetes_pod_name", labelFor("kubernetes.pod-name"))
+	assert.Equal(t, "_1st", labelFor("1st"))
+}
@@ -0,0 +1,305 @@
+package sink
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// The parts of MessagePack used by the Fluent Forward protocol
+
+// eventTimeExt is the extension type Fluentd uses for times with nanoseconds
+const eventTimeExt = 0
+
+var errMsgpack = errors.New("invalid msgpack")
+
+const (
+	// maxAckLength is the longest string and the most array or map elements read in an ack, which is a small map
+	maxAckLength = 256
+
+	// maxMsgpackDepth is the most arrays and maps read inside each other
+	maxMsgpackDepth = 32
+)
+
+// appendUint16 and the other append functions write big endian integers
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xde), uint16(n))
+	}
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xdc), uint16(n))
+	}
+	return appendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = appendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	}
+	return appendUint64(append(b, 0xd3), uint64(i))
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(f))
+}
+
+// appendEventTime writes a time as a Fluentd EventTime with nanoseconds
+func appendEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, eventTimeExt)
+	b = appendUint32(b, uint32(t.Unix()))
+	return appendUint32(b, uint32(t.Nanosecond()))
+}
+
+// appendValue writes a value decoded from JSON. Map keys are sorted so the output is the same each time.
+func appendValue(b []byte, v interface{}) []byte {
+
+	switch v := v.(type) {
+
+	case nil:
+		return append(b, 0xc0)
+
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+
+	case string:
+		return appendString(b, v)
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendInt(b, i)
+		}
+		f, _ := v.Float64()
+		return appendFloat(b, f)
+
+	case float64:
+		return appendFloat(b, v)
+
+	case int:
+		return appendInt(b, int64(v))
+
+	case int64:
+		return appendInt(b, v)
+
+	case []interface{}:
+		b = appendArrayHeader(b, len(v))
+		for _, e := range v {
+			b = appendValue(b, e)
+		}
+		return b
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = appendMapHeader(b, len(v))
+		for _, k := range keys {
+			b = appendString(b, k)
+			b = appendValue(b, v[k])
+		}
+		return b
+	}
+
+	return appendString(b, fmt.Sprint(v))
+}
+
+// msgpackReader decodes values for reading acks. Lengths over limit are rejected so a broken server
+// cannot make it allocate more than a little memory.
+type msgpackReader struct {
+	r     io.Reader
+	limit int
+}
+
+func (m msgpackReader) bytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(m.r, b)
+	return b, err
+}
+
+func (m msgpackReader) uint(n int) (uint64, error) {
+	b, err := m.bytes(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// read decodes the next value. Maps are map[string]interface{}, integers int64, and EventTimes time.Time.
+func (m msgpackReader) read() (interface{}, error) {
+	return m.readValue(0)
+}
+
+func (m msgpackReader) readValue(depth int) (interface{}, error) {
+
+	if depth > maxMsgpackDepth {
+		return nil, errMsgpack
+	}
+
+	b, err := m.bytes(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return m.readMap(uint64(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return m.readArray(uint64(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return m.readString(uint64(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := m.uint(1 << (c - 0xcc))
+		return int64(u), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		u, err := m.uint(n)
+		shift := 64 - 8*n
+		return int64(u<<shift) >> shift, err
+	case 0xca:
+		u, err := m.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := m.uint(8)
+		return math.Float64frombits(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := m.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return m.readString(n)
+	case 0xdc, 0xdd:
+		n, err := m.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return m.readArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := m.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return m.readMap(n, depth)
+	case 0xd7:
+		b, err := m.bytes(9)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != eventTimeExt {
+			return nil, errMsgpack
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(b[1:])), int64(binary.BigEndian.Uint32(b[5:]))), nil
+	}
+
+	return nil, errMsgpack
+}
+
+func (m msgpackReader) readString(n uint64) (string, error) {
+	if n > uint64(m.limit) {
+		return "", errMsgpack
+	}
+	b, err := m.bytes(int(n))
+	return string(b), err
+}
+
+func (m msgpackReader) readArray(n uint64, depth int) ([]interface{}, error) {
+	if n > uint64(m.limit) {
+		return nil, errMsgpack
+	}
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := m.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (m msgpackReader) readMap(n uint64, depth int) (map[string]interface{}, error) {
+	if n > uint64(m.limit) {
+		return nil, errMsgpack
+	}
+	mp := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := m.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errMsgpack
+		}
+		if mp[key], err = m.readValue(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return mp, nil
+}
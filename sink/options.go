@@ -0,0 +1,323 @@
+package sink
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/realugbun/logger"
+)
+
+// Formats of the body of a batch
+const (
+	// FormatNDJSON sends one entry per line
+	FormatNDJSON = "ndjson"
+
+	// FormatJSONArray sends the entries as a JSON array
+	FormatJSONArray = "json_array"
+)
+
+const (
+	defaultMaxEntries    = 500
+	defaultMaxBytes      = 1 << 20
+	defaultMaxPending    = 10000
+	defaultFlushInterval = time.Second
+	defaultMaxRetries    = 5
+	defaultMinBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff    = 10 * time.Second
+	defaultCloseTimeout  = 5 * time.Second
+	defaultSpoolMaxBytes = 256 << 20
+)
+
+// Options for sinks which send batches of entries
+type Options struct {
+
+	// MaxEntries is the most entries sent in one batch. Defaults to 500.
+	MaxEntries *int
+
+	// MaxBytes is the most bytes of entries sent in one batch before compression. Defaults to 1 MiB.
+	MaxBytes *int
+
+	// FlushInterval is how long entries wait before a batch which is not full is sent. Defaults to 1s.
+	FlushInterval *time.Duration
+
+	// MaxPending is the most entries held while batches are being sent or retried. The oldest are
+	// dropped and reported to OnError when it is reached. Defaults to 10000.
+	MaxPending *int
+
+	// MaxRetries is the number of times a failed batch is sent again. Defaults to 5.
+	MaxRetries *int
+
+	// MinBackoff is the wait before the first retry. It doubles for each retry after with random jitter. Defaults to 100ms.
+	MinBackoff *time.Duration
+
+	// MaxBackoff is the longest wait between retries. Defaults to 10s.
+	MaxBackoff *time.Duration
+
+	// CloseTimeout is how long Close waits for the last batches to be sent. Defaults to 5s.
+	CloseTimeout *time.Duration
+
+	// SpoolDir is a directory where batches which could not be sent are kept until the service recovers.
+	// They are sent in order before newer batches, including after a restart. Each sink needs its own directory.
+	SpoolDir *string
+
+	// SpoolMaxBytes is the most disk space used by the spool. Batches are dropped and reported to OnError
+	// when it is full. Defaults to 256 MiB.
+	SpoolMaxBytes *int64
+
+	// Gzip compresses each batch. Defaults to true.
+	Gzip *bool
+
+	// Format of the body for the HTTP sink: ndjson or json_array. Defaults to ndjson.
+	Format *string
+
+	// Headers are added to each request ie Authorization
+	Headers http.Header
+
+	// Client sends the requests. Defaults to a client with a 30s timeout.
+	Client *http.Client
+
+	// OnError is called with a *BatchError when a batch could not be sent or entries were dropped.
+	// It must not log with the logger. Defaults to writing the error to standard error.
+	OnError func(error)
+}
+
+func NewOptions() *Options {
+	return new(Options)
+}
+
+// clone copies the options so changes made after the sink is created have no effect
+func (o *Options) clone() *Options {
+	c := *o
+	c.Headers = o.Headers.Clone()
+	return &c
+}
+
+func (o *Options) SetMaxEntries(n int) *Options {
+	o.MaxEntries = &n
+	return o
+}
+
+func (o *Options) GetMaxEntries() int {
+	if o.MaxEntries == nil {
+		return defaultMaxEntries
+	}
+	return *o.MaxEntries
+}
+
+func (o *Options) SetMaxBytes(n int) *Options {
+	o.MaxBytes = &n
+	return o
+}
+
+func (o *Options) GetMaxBytes() int {
+	if o.MaxBytes == nil {
+		return defaultMaxBytes
+	}
+	return *o.MaxBytes
+}
+
+func (o *Options) SetFlushInterval(d time.Duration) *Options {
+	o.FlushInterval = &d
+	return o
+}
+
+func (o *Options) GetFlushInterval() time.Duration {
+	if o.FlushInterval == nil {
+		return defaultFlushInterval
+	}
+	return *o.FlushInterval
+}
+
+func (o *Options) SetMaxPending(n int) *Options {
+	o.MaxPending = &n
+	return o
+}
+
+func (o *Options) GetMaxPending() int {
+	if o.MaxPending == nil {
+		return defaultMaxPending
+	}
+	return *o.MaxPending
+}
+
+func (o *Options) SetMaxRetries(n int) *Options {
+	o.MaxRetries = &n
+	return o
+}
+
+func (o *Options) GetMaxRetries() int {
+	if o.MaxRetries == nil {
+		return defaultMaxRetries
+	}
+	return *o.MaxRetries
+}
+
+func (o *Options) SetMinBackoff(d time.Duration) *Options {
+	o.MinBackoff = &d
+	return o
+}
+
+func (o *Options) GetMinBackoff() time.Duration {
+	if o.MinBackoff == nil {
+		return defaultMinBackoff
+	}
+	return *o.MinBackoff
+}
+
+func (o *Options) SetMaxBackoff(d time.Duration) *Options {
+	o.MaxBackoff = &d
+	return o
+}
+
+func (o *Options) GetMaxBackoff() time.Duration {
+	if o.MaxBackoff == nil {
+		return defaultMaxBackoff
+	}
+	return *o.MaxBackoff
+}
+
+func (o *Options) SetCloseTimeout(d time.Duration) *Options {
+	o.CloseTimeout = &d
+	return o
+}
+
+func (o *Options) GetCloseTimeout() time.Duration {
+	if o.CloseTimeout == nil {
+		return defaultCloseTimeout
+	}
+	return *o.CloseTimeout
+}
+
+func (o *Options) SetSpoolDir(dir string) *Options {
+	o.SpoolDir = &dir
+	return o
+}
+
+func (o *Options) GetSpoolDir() string {
+	if o.SpoolDir == nil {
+		return ""
+	}
+	return *o.SpoolDir
+}
+
+func (o *Options) SetSpoolMaxBytes(n int64) *Options {
+	o.SpoolMaxBytes = &n
+	return o
+}
+
+func (o *Options) GetSpoolMaxBytes() int64 {
+	if o.SpoolMaxBytes == nil {
+		return defaultSpoolMaxBytes
+	}
+	return *o.SpoolMaxBytes
+}
+
+func (o *Options) SetGzip(gzip bool) *Options {
+	o.Gzip = &gzip
+	return o
+}
+
+func (o *Options) GetGzip() bool {
+	if o.Gzip == nil {
+		return true
+	}
+	return *o.Gzip
+}
+
+func (o *Options) SetFormat(format string) *Options {
+	o.Format = &format
+	return o
+}
+
+func (o *Options) GetFormat() string {
+	if o.Format == nil {
+		return FormatNDJSON
+	}
+	return *o.Format
+}
+
+// SetHeader sets a header sent with each request
+func (o *Options) SetHeader(key, value string) *Options {
+	if o.Headers == nil {
+		o.Headers = make(http.Header)
+	}
+	o.Headers.Set(key, value)
+	return o
+}
+
+func (o *Options) SetClient(client *http.Client) *Options {
+	o.Client = client
+	return o
+}
+
+func (o *Options) GetClient() *http.Client {
+	if o.Client == nil {
+		return defaultClient
+	}
+	return o.Client
+}
+
+func (o *Options) SetOnError(fn func(error)) *Options {
+	o.OnError = fn
+	return o
+}
+
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// Validate checks the options for invalid values. It returns a *logger.OptionError for the first problem found.
+func (o *Options) Validate() error {
+
+	for _, v := range []struct {
+		option string
+		value  int
+	}{
+		{"MaxEntries", o.GetMaxEntries()},
+		{"MaxBytes", o.GetMaxBytes()},
+		{"MaxPending", o.GetMaxPending()},
+	} {
+		if v.value < 1 {
+			return &logger.OptionError{Option: v.option, Reason: "must be positive"}
+		}
+	}
+
+	if o.GetMaxPending() < o.GetMaxEntries() {
+		return &logger.OptionError{Option: "MaxPending", Reason: "must be at least MaxEntries"}
+	}
+
+	if o.GetMaxRetries() < 0 {
+		return &logger.OptionError{Option: "MaxRetries", Reason: "must not be negative"}
+	}
+
+	for _, v := range []struct {
+		option string
+		value  time.Duration
+	}{
+		{"FlushInterval", o.GetFlushInterval()},
+		{"MinBackoff", o.GetMinBackoff()},
+		{"CloseTimeout", o.GetCloseTimeout()},
+	} {
+		if v.value <= 0 {
+			return &logger.OptionError{Option: v.option, Reason: "must be positive"}
+		}
+	}
+
+	if o.GetMaxBackoff() < o.GetMinBackoff() {
+		return &logger.OptionError{Option: "MaxBackoff", Reason: "must be at least MinBackoff"}
+	}
+
+	if o.SpoolDir != nil && o.GetSpoolDir() == "" {
+		return &logger.OptionError{Option: "SpoolDir", Reason: "directory name is empty"}
+	}
+
+	if o.GetSpoolMaxBytes() < int64(o.GetMaxBytes()) {
+		return &logger.OptionError{Option: "SpoolMaxBytes", Reason: "must be at least MaxBytes"}
+	}
+
+	switch o.GetFormat() {
+	case FormatNDJSON, FormatJSONArray:
+	default:
+		return &logger.OptionError{Option: "Format", Reason: "must be ndjson or json_array"}
+	}
+
+	return nil
+}
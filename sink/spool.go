@@ -0,0 +1,471 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentExt     = ".seg"
+	checkpointName = "checkpoint"
+
+	// recordHeaderSize is the payload length and CRC before each record
+	recordHeaderSize = 8
+
+	// maxSegmentSize is the size at which a new segment is started
+	maxSegmentSize = 4 << 20
+
+	// maxRecordSize rejects lengths which cannot have been written so a damaged segment is not read into memory
+	maxRecordSize = 1 << 30
+)
+
+// errSpoolFull is the reason a batch is dropped when SpoolMaxBytes is reached
+var errSpoolFull = errors.New("spool is full")
+
+// errCheckpoint is reported when the checkpoint cannot be read and the spool is sent again from its oldest segment
+var errCheckpoint = errors.New("invalid spool checkpoint, sending the spool again from the oldest batch")
+
+// spool is a queue of batches on disk which survives restarts.
+//
+// Batches are appended as records to numbered segment files. Each record is a 4 byte big endian length,
+// the 4 byte CRC-32 of the payload, and the payload which is the entries joined by new lines.
+// The checkpoint file holds the segment and offset of the next record to send. Segments are removed once
+// they have been sent.
+type spool struct {
+	dir          string
+	maxBytes     int64
+	segmentLimit int64
+
+	// segments are the numbers of the segment files from oldest to newest
+	segments []uint64
+
+	// next is the number of the next segment. Numbers are not reused so an old checkpoint cannot point into a new segment.
+	next uint64
+
+	// size is the bytes in the segments which have not been sent
+	size int64
+
+	// read is the offset in the first segment of the next record
+	read int64
+
+	// tail is the newest segment opened for appending
+	tail     *os.File
+	tailSize int64
+
+	// checkpointErr is set when the checkpoint could not be read so the spool starts from its oldest segment.
+	// Batches sent before the crash which damaged it are sent again.
+	checkpointErr error
+}
+
+func segmentName(n uint64) string {
+	return fmt.Sprintf("%020d%s", n, segmentExt)
+}
+
+// openSpool opens the spool in dir, creating it if needed, and continues from the checkpoint
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &spool{dir: dir, maxBytes: maxBytes, segmentLimit: maxSegmentSize}
+	if s.segmentLimit > maxBytes/4 {
+		s.segmentLimit = maxBytes / 4
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if n, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExt), 10, 64); err == nil && strings.HasSuffix(f.Name(), segmentExt) {
+			s.segments = append(s.segments, n)
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+	if len(s.segments) > 0 {
+		s.next = s.segments[len(s.segments)-1] + 1
+	}
+
+	if err := s.readCheckpoint(); err == errCheckpoint {
+		s.checkpointErr = err
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i, n := range s.segments {
+		info, err := os.Stat(filepath.Join(dir, segmentName(n)))
+		if err != nil {
+			return nil, err
+		}
+		s.size += info.Size()
+		if i == 0 {
+			s.size -= s.read
+		}
+	}
+
+	if len(s.segments) > 0 {
+		if err := s.openTail(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// readCheckpoint removes the segments before the checkpoint and sets the read offset. It returns errCheckpoint
+// when the checkpoint is damaged.
+func (s *spool) readCheckpoint() error {
+
+	b, err := os.ReadFile(filepath.Join(s.dir, checkpointName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var segment uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(b), "%d %d", &segment, &offset); err != nil || offset < 0 {
+		return errCheckpoint
+	}
+
+	// An offset past the end of its segment is damaged too so it is checked before anything is removed
+	for _, n := range s.segments {
+		if n != segment {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(s.dir, segmentName(n)))
+		if err != nil {
+			return err
+		}
+		if offset > info.Size() {
+			return errCheckpoint
+		}
+	}
+
+	for len(s.segments) > 0 && s.segments[0] < segment {
+		if err := os.Remove(filepath.Join(s.dir, segmentName(s.segments[0]))); err != nil {
+			return err
+		}
+		s.segments = s.segments[1:]
+	}
+
+	if len(s.segments) > 0 && s.segments[0] == segment {
+		s.read = offset
+	}
+	if segment > s.next {
+		s.next = segment
+	}
+	return nil
+}
+
+// openTail opens the newest segment for appending after removing a record cut off by a crash
+func (s *spool) openTail() error {
+
+	name := filepath.Join(s.dir, segmentName(s.segments[len(s.segments)-1]))
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	end, err := validEnd(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if end < info.Size() {
+		s.size -= info.Size() - end
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.tail, s.tailSize = f, end
+	return nil
+}
+
+// validEnd finds the end of the last complete record in a segment
+func validEnd(f *os.File) (int64, error) {
+
+	var offset int64
+	for {
+		_, n, err := readRecord(f, offset)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorrupt {
+				return offset, nil
+			}
+			return 0, err
+		}
+		offset += n
+	}
+}
+
+var errCorrupt = errors.New("spool record is corrupt")
+
+// readRecord reads the record at offset. It returns the payload and the size of the record.
+func readRecord(r io.ReaderAt, offset int64) ([]byte, int64, error) {
+
+	header := make([]byte, recordHeaderSize)
+	if n, err := r.ReadAt(header, offset); n < len(header) {
+		if n == 0 && err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > maxRecordSize {
+		return nil, 0, errCorrupt
+	}
+
+	payload := make([]byte, size)
+	if n, err := r.ReadAt(payload, offset+recordHeaderSize); n < len(payload) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errCorrupt
+	}
+
+	return payload, recordHeaderSize + int64(size), nil
+}
+
+// empty is true when every batch has been sent
+func (s *spool) empty() bool {
+	return s.size == 0
+}
+
+// push appends a batch. It returns errSpoolFull when the batch would take the spool over its size.
+func (s *spool) push(batch [][]byte) error {
+
+	payload := bytes.Join(batch, []byte("\n"))
+	size := int64(recordHeaderSize + len(payload))
+
+	if s.size+size > s.maxBytes {
+		return errSpoolFull
+	}
+
+	if s.tail == nil || (s.tailSize > 0 && s.tailSize+size > s.segmentLimit) {
+		if err := s.newSegment(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, recordHeaderSize, size)
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+
+	if _, err := s.tail.Write(record); err != nil {
+		return err
+	}
+	if err := s.tail.Sync(); err != nil {
+		return err
+	}
+
+	s.tailSize += size
+	s.size += size
+	return nil
+}
+
+func (s *spool) newSegment() error {
+
+	n := s.next
+
+	f, err := os.OpenFile(filepath.Join(s.dir, segmentName(n)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if s.tail != nil {
+		s.tail.Close()
+	}
+	s.next++
+	s.segments = append(s.segments, n)
+	s.tail, s.tailSize = f, 0
+	return nil
+}
+
+// peek reads the oldest batch. A corrupt record is returned as errCorrupt with its segment skipped
+// so the next peek continues with the following segment.
+func (s *spool) peek() ([][]byte, error) {
+
+	for !s.empty() {
+
+		f, err := os.Open(filepath.Join(s.dir, segmentName(s.segments[0])))
+		if err != nil {
+			return nil, err
+		}
+		payload, _, err := readRecord(f, s.read)
+		f.Close()
+
+		switch {
+		case err == nil:
+			return bytes.Split(payload, []byte("\n")), nil
+
+		// The rest of a segment which is not being appended to is passed over
+		case err == io.EOF && len(s.segments) > 1:
+			if err := s.removeFirst(); err != nil {
+				return nil, err
+			}
+
+		case err == io.EOF:
+			return nil, nil
+
+		case err == errCorrupt || err == io.ErrUnexpectedEOF:
+			if err := s.removeFirst(); err != nil {
+				return nil, err
+			}
+			return nil, errCorrupt
+
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// pop removes the oldest batch after it was sent
+func (s *spool) pop() error {
+
+	f, err := os.Open(filepath.Join(s.dir, segmentName(s.segments[0])))
+	if err != nil {
+		return err
+	}
+	_, n, err := readRecord(f, s.read)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	s.read += n
+	s.size -= n
+
+	// Once everything has been sent the segments are removed so the spool starts again
+	if s.empty() {
+		return s.reset()
+	}
+
+	return s.writeCheckpoint()
+}
+
+// removeFirst removes the oldest segment
+func (s *spool) removeFirst() error {
+
+	info, err := os.Stat(filepath.Join(s.dir, segmentName(s.segments[0])))
+	if err != nil {
+		return err
+	}
+	s.size -= info.Size() - s.read
+
+	if len(s.segments) == 1 {
+		return s.reset()
+	}
+
+	if err := os.Remove(filepath.Join(s.dir, segmentName(s.segments[0]))); err != nil {
+		return err
+	}
+	s.segments = s.segments[1:]
+	s.read = 0
+	return s.writeCheckpoint()
+}
+
+// reset removes every segment and the checkpoint
+func (s *spool) reset() error {
+
+	if s.tail != nil {
+		s.tail.Close()
+		s.tail = nil
+	}
+
+	for _, n := range s.segments {
+		if err := os.Remove(filepath.Join(s.dir, segmentName(n))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	s.segments = nil
+	s.size, s.read = 0, 0
+	return s.saveCheckpoint(s.next, 0)
+}
+
+// writeCheckpoint saves the read position
+func (s *spool) writeCheckpoint() error {
+	return s.saveCheckpoint(s.segments[0], s.read)
+}
+
+// saveCheckpoint replaces the checkpoint file so a crash leaves the old or the new checkpoint. The new one is
+// synced before it replaces the old one and the directory after so neither can be lost or left empty.
+func (s *spool) saveCheckpoint(segment uint64, offset int64) error {
+
+	tmp := filepath.Join(s.dir, checkpointName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%d %d\n", segment, offset); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, checkpointName)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// syncDir makes a rename in dir durable. Directories cannot be opened for syncing on Windows so it does nothing there.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && runtime.GOOS != "windows" {
+		return err
+	}
+	return nil
+}
+
+func (s *spool) close() error {
+	if s.tail == nil {
+		return nil
+	}
+	return s.tail.Close()
+}
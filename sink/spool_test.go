@@ -0,0 +1,220 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func batchOf(entries ...string) [][]byte {
+	b := make([][]byte, len(entries))
+	for i, e := range entries {
+		b[i] = []byte(e)
+	}
+	return b
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.NoError(t, err)
+	for i, n := range names {
+		names[i] = filepath.Base(n)
+	}
+	return names
+}
+
+func Test_spool(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// Small enough that each batch starts a new segment
+	s, err := openSpool(dir, 100)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, s.empty())
+
+	for _, b := range [][][]byte{batchOf(`{"n":1}`, `{"n":2}`), batchOf(`{"n":3}`), batchOf(`{"n":4}`)} {
+		assert.NoError(t, s.push(b))
+	}
+	assert.Equal(t, errSpoolFull, s.push(batchOf(strings.Repeat("x", 60))))
+	assert.Len(t, segmentFiles(t, dir), 3)
+
+	batch, err := s.peek()
+	assert.NoError(t, err)
+	assert.Equal(t, batchOf(`{"n":1}`, `{"n":2}`), batch)
+	assert.NoError(t, s.pop())
+	assert.NoError(t, s.close())
+
+	// A restart continues from the checkpoint and drops a record cut off by a crash
+	f, _ := os.OpenFile(filepath.Join(dir, segmentName(2)), os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0, 0, 0, 20, 1})
+	f.Close()
+
+	s, err = openSpool(dir, 100)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, s.push(batchOf(`{"n":5}`)))
+
+	var got [][]byte
+	for !s.empty() {
+		batch, err := s.peek()
+		if !assert.NoError(t, err) {
+			return
+		}
+		got = append(got, batch...)
+		assert.NoError(t, s.pop())
+	}
+	assert.Equal(t, batchOf(`{"n":3}`, `{"n":4}`, `{"n":5}`), got)
+
+	// Segments are removed once sent and the numbering continues
+	assert.Empty(t, segmentFiles(t, dir))
+	assert.NoError(t, s.push(batchOf(`{"n":6}`)))
+	assert.Equal(t, []string{segmentName(4)}, segmentFiles(t, dir))
+	s.close()
+}
+
+func Test_spoolCorrupt(t *testing.T) {
+
+	dir := t.TempDir()
+	s, _ := openSpool(dir, 1<<20)
+	s.segmentLimit = 1
+	s.push(batchOf(`{"n":1}`))
+	s.push(batchOf(`{"n":2}`))
+
+	// Damage the first segment
+	name := filepath.Join(dir, segmentName(0))
+	b, _ := os.ReadFile(name)
+	b[len(b)-1] ^= 1
+	os.WriteFile(name, b, 0o644)
+
+	_, err := s.peek()
+	assert.Equal(t, errCorrupt, err)
+
+	batch, err := s.peek()
+	assert.NoError(t, err)
+	assert.Equal(t, batchOf(`{"n":2}`), batch)
+	s.close()
+}
+
+func Test_spoolCheckpointDamaged(t *testing.T) {
+
+	for _, checkpoint := range []string{"", "1", "1 -2\n", "1 4096\n"} {
+
+		dir := t.TempDir()
+		s, _ := openSpool(dir, 1<<20)
+		s.segmentLimit = 1
+		s.push(batchOf(`{"n":1}`))
+		s.push(batchOf(`{"n":2}`))
+		s.pop()
+		s.close()
+
+		// A crash left the checkpoint empty or torn so the spool starts again from the oldest segment
+		os.WriteFile(filepath.Join(dir, checkpointName), []byte(checkpoint), 0o644)
+
+		s, err := openSpool(dir, 1<<20)
+		if !assert.NoError(t, err, checkpoint) {
+			continue
+		}
+		assert.Equal(t, errCheckpoint, s.checkpointErr, checkpoint)
+
+		batch, err := s.peek()
+		assert.NoError(t, err, checkpoint)
+		assert.Equal(t, batchOf(`{"n":1}`), batch, checkpoint)
+		s.close()
+	}
+
+	// The sink starts and the problem is reported
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, checkpointName), nil, 0o644)
+
+	var errs errorRecorder
+	b, err := newBatcher(NewOptions().SetSpoolDir(dir).SetOnError(errs.onError), func(ctx context.Context, entries [][]byte) error { return nil })
+	if assert.NoError(t, err) {
+		b.Close()
+		assert.Equal(t, []error{&BatchError{Err: errCheckpoint}}, errs.get())
+	}
+}
+
+// flakyService fails while down is set and records what was sent
+type flakyService struct {
+	mu   sync.Mutex
+	down bool
+	sent []string
+}
+
+func (f *flakyService) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyService) send(ctx context.Context, entries [][]byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("connection refused")
+	}
+	for _, e := range entries {
+		f.sent = append(f.sent, string(e))
+	}
+	return nil
+}
+
+func (f *flakyService) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+func Test_batcherSpool(t *testing.T) {
+
+	dir := t.TempDir()
+	svc := &flakyService{down: true}
+
+	var errs errorRecorder
+	o := NewOptions().SetSpoolDir(dir).SetMaxEntries(2).SetMaxRetries(0).SetMinBackoff(time.Millisecond).
+		SetMaxBackoff(time.Millisecond).SetFlushInterval(time.Hour).SetOnError(errs.onError)
+
+	b, err := newBatcher(o, svc.send)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, e := range []string{"1", "2", "3"} {
+		b.Write([]byte(e + "\n"))
+	}
+	assert.NoError(t, b.Flush())
+
+	// Batches written while the spool has entries are sent after them
+	svc.setDown(false)
+	time.Sleep(5 * time.Millisecond)
+	b.Write([]byte("4\n"))
+	assert.NoError(t, b.Flush())
+	assert.Equal(t, []string{"1", "2", "3", "4"}, svc.received())
+
+	// The spool survives a restart while the service is down
+	svc.setDown(true)
+	b.Write([]byte("5\n"))
+	b.Close()
+	assert.NotEmpty(t, segmentFiles(t, dir))
+
+	svc.setDown(false)
+	b, err = newBatcher(o, svc.send)
+	if !assert.NoError(t, err) {
+		return
+	}
+	b.Write([]byte("6\n"))
+	b.Close()
+
+	assert.Equal(t, []string{"1", "2", "3", "4", "5", "6"}, svc.received())
+	assert.Empty(t, segmentFiles(t, dir))
+	assert.Empty(t, errs.get())
+}
@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"io"
+	"reflect"
+)
+
+// teeWriter writes each entry to the output then to the sinks
+type teeWriter struct {
+	out   io.Writer
+	sinks []io.WriteCloser
+}
+
+// Write returns the result of the output. Sinks report their own errors.
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.out.Write(p)
+	for _, s := range t.sinks {
+		s.Write(p)
+	}
+	return n, err
+}
+
+// setSinks closes the sinks which are not in next. It is called after the output changed
+// so nothing is written to them once closed.
+func setSinks(next []io.WriteCloser) error {
+
+	var err error
+	for _, s := range sinks {
+		if containsSink(next, s) {
+			continue
+		}
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	sinks = next
+	return err
+}
+
+func containsSink(sinks []io.WriteCloser, sink io.WriteCloser) bool {
+	if !reflect.TypeOf(sink).Comparable() {
+		return false
+	}
+	for _, s := range sinks {
+		if reflect.TypeOf(s) == reflect.TypeOf(sink) && s == sink {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,502 @@
+package logger
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// How query arguments are logged
+const (
+	// SQLArgsNone leaves the arguments out
+	SQLArgsNone = "none"
+
+	// SQLArgsRedacted logs numbers, booleans, times, and nulls but replaces strings and bytes with [REDACTED]
+	SQLArgsRedacted = "redacted"
+
+	// SQLArgsRaw logs the arguments as they are passed to the driver
+	SQLArgsRaw = "raw"
+)
+
+// sqlSkipPackages are skipped when finding the caller so it is the application code using database/sql
+var sqlSkipPackages = []string{"database/sql", "database/sql/driver"}
+
+// SQLOptions for logging database queries
+type SQLOptions struct {
+
+	// Level is the level queries are logged at. Defaults to debug.
+	Level *string
+
+	// SlowThreshold logs queries taking longer at level Warn. Zero disables it.
+	SlowThreshold *time.Duration
+
+	// Args sets how query arguments are logged: none, redacted, or raw. Defaults to redacted.
+	Args *string
+}
+
+func NewSQLOptions() *SQLOptions {
+	return new(SQLOptions)
+}
+
+func (o *SQLOptions) SetLevel(level string) *SQLOptions {
+	o.Level = &level
+	return o
+}
+
+func (o *SQLOptions) GetLevel() string {
+	if o.Level == nil {
+		return logrus.DebugLevel.String()
+	}
+	return *o.Level
+}
+
+func (o *SQLOptions) SetSlowThreshold(d time.Duration) *SQLOptions {
+	o.SlowThreshold = &d
+	return o
+}
+
+func (o *SQLOptions) GetSlowThreshold() time.Duration {
+	if o.SlowThreshold == nil {
+		return 0
+	}
+	return *o.SlowThreshold
+}
+
+func (o *SQLOptions) SetArgs(mode string) *SQLOptions {
+	o.Args = &mode
+	return o
+}
+
+func (o *SQLOptions) GetArgs() string {
+	if o.Args == nil {
+		return SQLArgsRedacted
+	}
+	return *o.Args
+}
+
+// sqlLogger logs the queries for a wrapped driver
+type sqlLogger struct {
+	level   logrus.Level
+	slow    time.Duration
+	args    string
+	entries *Entry
+}
+
+func newSQLLogger(o *SQLOptions) *sqlLogger {
+	level, err := logrus.ParseLevel(strings.ToLower(o.GetLevel()))
+	if err != nil {
+		level = logrus.DebugLevel
+	}
+	return &sqlLogger{
+		level:   level,
+		slow:    o.GetSlowThreshold(),
+		args:    o.GetArgs(),
+		entries: std.AddSkipPackages(sqlSkipPackages...),
+	}
+}
+
+// log writes a query with its duration, arguments, and outcome.
+// Errors are logged at level Error and slow queries at level Warn.
+func (l *sqlLogger) log(msg, query string, args []driver.NamedValue, start time.Time, rowsAffected *int64, err error) {
+
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+
+	d := time.Since(start)
+
+	level := l.level
+	switch {
+	case err != nil:
+		level = logrus.ErrorLevel
+	case l.slow > 0 && d >= l.slow:
+		level = logrus.WarnLevel
+	}
+
+	if !logrus.IsLevelEnabled(level) {
+		return
+	}
+
+	fields := Fields{
+		"duration_ms": float64(d) / float64(time.Millisecond),
+	}
+	if query != "" {
+		fields["query"] = query
+	}
+	if a := l.formatArgs(args); a != nil {
+		fields["args"] = a
+	}
+	if rowsAffected != nil {
+		fields["rows_affected"] = *rowsAffected
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	if level == logrus.WarnLevel {
+		fields["slow"] = true
+	}
+
+	l.entries.WithFields(fields).log(level, msg)
+}
+
+func (l *sqlLogger) formatArgs(args []driver.NamedValue) []interface{} {
+
+	if len(args) == 0 || l.args == SQLArgsNone {
+		return nil
+	}
+
+	out := make([]interface{}, len(args))
+	for i, a := range args {
+		v := a.Value
+		if l.args != SQLArgsRaw {
+			switch v.(type) {
+			case string, []byte:
+				v = redacted
+			}
+		}
+		if a.Name != "" {
+			v = map[string]interface{}{a.Name: v}
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// WrapDriver wraps a database driver to log queries with default options.
+// Register the wrapped driver with sql.Register then open it with sql.Open.
+func WrapDriver(d driver.Driver) driver.Driver {
+	return WrapDriverWithOptions(d, NewSQLOptions())
+}
+
+// WrapDriverWithOptions wraps a database driver to log each query with its arguments, rows affected,
+// duration, and error. The caller is the application code rather than database/sql.
+func WrapDriverWithOptions(d driver.Driver, o *SQLOptions) driver.Driver {
+	return &sqlDriver{base: d, logger: newSQLLogger(o)}
+}
+
+// WrapConnector wraps a database connector to log queries with default options. Open it with sql.OpenDB.
+func WrapConnector(c driver.Connector) driver.Connector {
+	return WrapConnectorWithOptions(c, NewSQLOptions())
+}
+
+// WrapConnectorWithOptions wraps a database connector to log queries like WrapDriverWithOptions
+func WrapConnectorWithOptions(c driver.Connector, o *SQLOptions) driver.Connector {
+	l := newSQLLogger(o)
+	return &sqlConnector{base: c, driver: &sqlDriver{base: c.Driver(), logger: l}, logger: l}
+}
+
+type sqlDriver struct {
+	base   driver.Driver
+	logger *sqlLogger
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{base: c, logger: d.logger}, nil
+}
+
+func (d *sqlDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.base.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlConnector{base: c, driver: d, logger: d.logger}, nil
+	}
+	return &sqlConnector{base: dsnConnector{name: name, driver: d.base}, driver: d, logger: d.logger}, nil
+}
+
+// dsnConnector opens connections for drivers which do not implement driver.DriverContext
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type sqlConnector struct {
+	base   driver.Connector
+	driver *sqlDriver
+	logger *sqlLogger
+}
+
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{base: conn, logger: c.logger}, nil
+}
+
+func (c *sqlConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sqlConn logs the queries run on a connection. It implements the optional driver interfaces
+// and returns driver.ErrSkip when the wrapped connection does not so database/sql falls back.
+type sqlConn struct {
+	base   driver.Conn
+	logger *sqlLogger
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.base.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.base.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlStmt{base: s, query: query, logger: c.logger}, nil
+}
+
+func (c *sqlConn) Close() error {
+	return c.base.Close()
+}
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if bt, ok := c.base.(driver.ConnBeginTx); ok {
+		tx, err = bt.BeginTx(ctx, opts)
+	} else if opts.Isolation != driver.IsolationLevel(0) || opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support non-default isolation level or read only transactions")
+	} else {
+		tx, err = c.base.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlTx{base: tx, logger: c.logger}, nil
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+
+	start := time.Now()
+
+	var (
+		res driver.Result
+		err error
+	)
+	if ec, ok := c.base.(driver.ExecerContext); ok {
+		res, err = ec.ExecContext(ctx, query, args)
+	} else if e, ok := c.base.(driver.Execer); ok {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = e.Exec(query, values)
+		}
+	} else {
+		return nil, driver.ErrSkip
+	}
+
+	c.logger.log("sql exec", query, args, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+
+	start := time.Now()
+
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if qc, ok := c.base.(driver.QueryerContext); ok {
+		rows, err = qc.QueryContext(ctx, query, args)
+	} else if q, ok := c.base.(driver.Queryer); ok {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = q.Query(query, values)
+		}
+	} else {
+		return nil, driver.ErrSkip
+	}
+
+	c.logger.log("sql query", query, args, start, nil, err)
+	return rows, err
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.base.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if sr, ok := c.base.(driver.SessionResetter); ok {
+		return sr.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) IsValid() bool {
+	if v, ok := c.base.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.base.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// sqlStmt logs the queries run with a prepared statement
+type sqlStmt struct {
+	base   driver.Stmt
+	query  string
+	logger *sqlLogger
+}
+
+func (s *sqlStmt) Close() error {
+	return s.base.Close()
+}
+
+func (s *sqlStmt) NumInput() int {
+	return s.base.NumInput()
+}
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valueArgs(args))
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valueArgs(args))
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+
+	start := time.Now()
+
+	var (
+		res driver.Result
+		err error
+	)
+	if ec, ok := s.base.(driver.StmtExecContext); ok {
+		res, err = ec.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = s.base.Exec(values)
+		}
+	}
+
+	s.logger.log("sql exec", s.query, args, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+
+	start := time.Now()
+
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if qc, ok := s.base.(driver.StmtQueryContext); ok {
+		rows, err = qc.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = s.base.Query(values)
+		}
+	}
+
+	s.logger.log("sql query", s.query, args, start, nil, err)
+	return rows, err
+}
+
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.base.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// sqlTx logs when a transaction is committed or rolled back
+type sqlTx struct {
+	base   driver.Tx
+	logger *sqlLogger
+}
+
+func (t *sqlTx) Commit() error {
+	start := time.Now()
+	err := t.base.Commit()
+	t.logger.log("sql commit", "", nil, start, nil, err)
+	return err
+}
+
+func (t *sqlTx) Rollback() error {
+	start := time.Now()
+	err := t.base.Rollback()
+	t.logger.log("sql rollback", "", nil, start, nil, err)
+	return err
+}
+
+// rowsAffected gets the rows affected from a result if the driver reports it
+func rowsAffected(res driver.Result, err error) *int64 {
+	if err != nil || res == nil {
+		return nil
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// namedValues converts arguments for drivers which do not support named arguments
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, fmt.Errorf("sql: driver does not support the use of named parameters")
+		}
+		values[i] = a.Value
+	}
+	return values, nil
+}
+
+func valueArgs(values []driver.Value) []driver.NamedValue {
+	args := make([]driver.NamedValue, len(values))
+	for i, v := range values {
+		args[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return args
+}
@@ -0,0 +1,176 @@
+package logger
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver is an in memory driver which only implements the required interfaces
+// so the fallbacks used by database/sql are exercised.
+// Queries are handled by name: "insert" affects one row per argument, "slow" sleeps, and "fail" returns an error.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query: query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(s.query, "fail"):
+		return nil, errors.New("syntax error")
+	case strings.HasPrefix(s.query, "slow"):
+		time.Sleep(20 * time.Millisecond)
+	}
+	return driver.RowsAffected(len(args)), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+var registerFakeDriver sync.Once
+
+func Test_WrapDriver(t *testing.T) {
+
+	registerFakeDriver.Do(func() {
+		o := NewSQLOptions().SetLevel("info").SetSlowThreshold(10 * time.Millisecond)
+		sql.Register("logged-fake", WrapDriverWithOptions(fakeDriver{}, o))
+	})
+
+	db, err := sql.Open("logged-fake", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for _, tc := range []struct {
+		name      string
+		run       func() error
+		expMsg    string
+		expLevel  string
+		expFields map[string]interface{}
+	}{
+		{
+			name: "exec",
+			run: func() error {
+				_, err := db.Exec("insert into users values (?, ?, ?)", 1, "me@example.com", true)
+				return err
+			},
+			expMsg:   "sql exec",
+			expLevel: "info",
+			expFields: map[string]interface{}{
+				"query":         "insert into users values (?, ?, ?)",
+				"args":          []interface{}{float64(1), "[REDACTED]", true},
+				"rows_affected": float64(3),
+			},
+		},
+		{
+			name: "query",
+			run: func() error {
+				var id int
+				return db.QueryRow("select id from users where email = ?", "me@example.com").Scan(&id)
+			},
+			expMsg:   "sql query",
+			expLevel: "info",
+			expFields: map[string]interface{}{
+				"query": "select id from users where email = ?",
+			},
+		},
+		{
+			name: "slow",
+			run: func() error {
+				_, err := db.Exec("slow update")
+				return err
+			},
+			expMsg:   "sql exec",
+			expLevel: "warning",
+			expFields: map[string]interface{}{
+				"slow": true,
+			},
+		},
+		{
+			name: "error",
+			run: func() error {
+				_, err := db.Exec("fail")
+				assert.Error(t, err)
+				return nil
+			},
+			expMsg:   "sql exec",
+			expLevel: "error",
+			expFields: map[string]interface{}{
+				"error": "syntax error",
+			},
+		},
+		{
+			name: "transaction",
+			run: func() error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				return tx.Commit()
+			},
+			expMsg:   "sql commit",
+			expLevel: "info",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			entries := logEntries(t, NewOptions().SetIncludeFunc(true), func() {
+				assert.NoError(t, tc.run())
+			})
+
+			if !assert.Len(t, entries, 1) {
+				return
+			}
+			entry := entries[0]
+
+			assert.Equal(t, tc.expMsg, entry["msg"])
+			assert.Equal(t, tc.expLevel, entry["level"])
+			for k, v := range tc.expFields {
+				assert.Equal(t, v, entry[k], k)
+			}
+
+			// The caller is the test rather than database/sql
+			assert.True(t, strings.HasPrefix(entry["func"].(string), "logger.Test_WrapDriver"), entry["func"])
+		})
+	}
+}
@@ -1,55 +1,69 @@
 package logger
 
 import (
+	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
+// Stack trace formats for the trace field
+const (
+	// TraceFormatFields writes the trace as a list of objects with file, line, and function keys
+	TraceFormatFields = "fields"
+
+	// TraceFormatPanic writes the trace as a single string in the format Go uses when a program panics
+	TraceFormatPanic = "panic"
+
+	// TraceFormatCompact writes the trace as a list of func@file:line strings
+	TraceFormatCompact = "compact"
+)
+
 // stackTrace gets the file, line, and function name where the log message was called.
-// If stackTraceOptions are defined, it also attaches a stack trace.
+// If stack trace options are passed, it also attaches a stack trace.
 // To get an accurate stackTrace the log should be called within the function
 // instead of after returning from the function. This is important for errors.
 // logger.Error should be called within the function where the error happened
 // not after that function returns.
-func stackTrace() (fields logrus.Fields) {
-
-	// Don't include func name if disabled
-	if !options.GetIncludeFunc() {
-		return
-	}
+// Frames from skipPackages are skipped when finding the caller in addition to the SkipPackages option.
+// The options are those read at the start of the log call.
+func stackTrace(o *Options, level logrus.Level, st *StackTrace, skipPackages ...string) (fields logrus.Fields) {
 
 	var (
-		pc       = make([]uintptr, 15)
-		n        = runtime.Callers(2, pc)
-		frames   = runtime.CallersFrames(pc[:n])
-		isCaller bool
-		file     string
-		line     int
-		function string
-		trace    []map[string]interface{}
-		counter  int
+		frames    = newFrameIterator(4, maxDepth(st))
+		isCaller  = true
+		skip      = o.GetCallerSkip()
+		file      string
+		line      int
+		function  string
+		trace     []runtime.Frame
+		truncated bool
 	)
 
-	isMore := true
-	for isMore && counter < 500 {
+	for {
 
-		// This would prevent an infinate loop in some strage situation
-		counter++
-
-		frame, more := frames.Next()
-		isMore = more
+		frame, ok := frames.next()
+		if !ok {
+			// The stack was deeper than the capture depth allows
+			truncated = frames.truncated
+			break
+		}
 
-		// Skip frames to the logger package which should always be the first frame
-		if isLoggerCall(frame.File) {
-			// The next frame will be the line which called the logger
-			isCaller = true
+		// Skip frames to the logger package and any packages wrapping it.
+		// The first frame after them will be the line which called the logger.
+		if isCaller && isLoggerCall(o, frame, skipPackages...) {
+			continue
+		}
+		// Skip frames of wrapper functions outside of the skipped packages
+		if isCaller && skip > 0 {
+			skip--
 			continue
 		}
 		// Adds the file, line number, and function name to the main entry
 		if isCaller {
-			file = frame.File
+			file = formatPath(o, frame.File, frame.Function)
 			line = frame.Line
 			function = cleanFuncName(frame.Function)
 			isCaller = false
@@ -57,61 +71,246 @@ func stackTrace() (fields logrus.Fields) {
 		}
 
 		// Only add the stack trace if it is enabled
-		if options.StackTrace == nil {
+		if st == nil {
 			break
 		}
 
-		trace = append(trace, map[string]interface{}{
-			"file":     frame.File,
-			"line":     frame.Line,
-			"function": cleanFuncName(frame.Function),
-		})
-
-		if len(trace) == options.StackTrace.GetMaxEntries() {
-			break
+		// Leave out frames from the Go runtime and standard library if the option is enabled
+		if st.GetOmitGoRoot() && isGoRoot(frame.Function) {
+			continue
 		}
 
+		trace = append(trace, frame)
+
 		// Stop once we reach a particular function name such as main.main
-		if frame.Function == options.StackTrace.GetStopFunction() {
+		if frame.Function == st.GetStopFunction() {
 			break
 		}
 
 		// Stop once we reach a particular file name such as logger.go
-		if options.StackTrace.GetStopFile() != "" {
-			if strings.HasSuffix(frame.File, options.StackTrace.GetStopFile()) {
+		if st.GetStopFile() != "" {
+			if strings.HasSuffix(frame.File, st.GetStopFile()) {
 				break
 			}
 		}
 
 		// Stop when we get to the lambda caller if the option is enabled
-		if options.StackTrace.GetLambda() {
-			if strings.HasPrefix(frame.Function, options.StackTrace.GetStopFunction()) {
+		if st.GetLambda() {
+			if strings.HasPrefix(frame.Function, st.GetStopFunction()) {
 				break
 			}
 		}
 
+		// The trace is only truncated if a frame after the last entry would have been added
+		if len(trace) == st.GetMaxEntries() {
+			truncated = moreTrace(frames, st)
+			break
+		}
+
 	}
 
 	fields = logrus.Fields{
-		"file": file,
-		"line": line,
-		"func": function,
+		FieldKeyFile: file,
+		FieldKeyLine: line,
+		FieldKeyFunc: function,
 	}
 
 	if len(trace) > 0 {
-		fields["trace"] = trace
+		fields[FieldKeyTrace] = renderTrace(o, trace, st.GetFormat())
+		if truncated {
+			fields[FieldKeyTraceTruncated] = true
+		}
+	}
+
+	// Dump every goroutine when the program is about to panic or exit
+	if st != nil && st.GetAllGoroutines() && level <= logrus.FatalLevel {
+		fields[FieldKeyGoroutines] = allGoroutines()
 	}
 
 	return
 }
 
-// isLoggerCall checks if the stack trace is a call from the logger
-func isLoggerCall(file string) bool {
+// renderTrace formats the stack trace frames for the trace field
+func renderTrace(o *Options, trace []runtime.Frame, format string) interface{} {
+
+	switch format {
+
+	// Go panic style ie goroutine 1 [running]:\nmain.main()\n\t/app/main.go:12
+	case TraceFormatPanic:
+		var sb strings.Builder
+		sb.WriteString("goroutine " + goroutineID() + " [running]:")
+		for _, frame := range trace {
+			sb.WriteString("\n" + frame.Function + "(...)")
+			sb.WriteString("\n\t" + formatPath(o, frame.File, frame.Function) + ":" + strconv.Itoa(frame.Line))
+		}
+		return sb.String()
+
+	// A list of func@file:line strings
+	case TraceFormatCompact:
+		entries := make([]string, 0, len(trace))
+		for _, frame := range trace {
+			entries = append(entries, cleanFuncName(frame.Function)+"@"+formatPath(o, frame.File, frame.Function)+":"+strconv.Itoa(frame.Line))
+		}
+		return entries
+	}
+
+	entries := make([]map[string]interface{}, 0, len(trace))
+	for _, frame := range trace {
+		entries = append(entries, map[string]interface{}{
+			"file":     formatPath(o, frame.File, frame.Function),
+			"line":     frame.Line,
+			"function": cleanFuncName(frame.Function),
+		})
+	}
+	return entries
+}
+
+// goroutineID gets the id of the current goroutine from the header of its stack
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	fs := strings.Fields(string(buf))
+	if len(fs) < 2 {
+		return "0"
+	}
+	return fs[1]
+}
+
+// maxDepth is the number of frames that may be read from the call stack
+func maxDepth(st *StackTrace) int {
+	if st == nil {
+		return defaultDepth
+	}
+	return st.GetDepth()
+}
+
+// frameIterator reads the call stack a page of program counters at a time so deep stacks
+// are not cut off by a fixed size buffer. Pages are only read when the previous page runs out.
+type frameIterator struct {
+	pc        []uintptr
+	skip      int
+	remaining int
+	frames    *runtime.Frames
+	more      bool
+	last      bool
+	truncated bool
+}
+
+func newFrameIterator(skip, depth int) *frameIterator {
+	size := stackPageSize
+	if depth < size {
+		size = depth
+	}
+	return &frameIterator{
+		pc:        make([]uintptr, size),
+		skip:      skip,
+		remaining: depth,
+	}
+}
+
+// next returns the next frame, reading another page of the stack when needed
+func (it *frameIterator) next() (runtime.Frame, bool) {
+
+	if !it.more && !it.readPage() {
+		return runtime.Frame{}, false
+	}
+
+	frame, more := it.frames.Next()
+	it.more = more
+	return frame, true
+}
+
+// readPage loads the next page of program counters. It must only be called from next
+// so the number of frames to skip is the same for every page.
+func (it *frameIterator) readPage() bool {
 
-	fs := strings.Split(file, "/")
-	l := fs[len(fs)-2]
-	return strings.HasPrefix(l, "logger")
+	if it.last {
+		return false
+	}
+
+	if it.remaining <= 0 {
+		it.last = true
+		it.truncated = runtime.Callers(it.skip, make([]uintptr, 1)) > 0
+		return false
+	}
+
+	pc := it.pc
+	if it.remaining < len(pc) {
+		pc = pc[:it.remaining]
+	}
+
+	n := runtime.Callers(it.skip, pc)
+	if n == 0 {
+		it.last = true
+		return false
+	}
+
+	// A partial page means the bottom of the stack has been reached
+	if n < len(pc) {
+		it.last = true
+	}
+
+	it.skip += n
+	it.remaining -= n
+	it.frames = runtime.CallersFrames(pc[:n])
+	it.more = true
+	return true
+}
+
+// moreTrace checks if any frame left on the stack would be added to the trace.
+// Frames past the capture depth are unknown so they are assumed to be added.
+func moreTrace(frames *frameIterator, st *StackTrace) bool {
+	for {
+		frame, ok := frames.next()
+		if !ok {
+			return frames.truncated
+		}
+		if st.GetOmitGoRoot() && isGoRoot(frame.Function) {
+			continue
+		}
+		return true
+	}
+}
+
+// allGoroutines returns the stacks of every running goroutine in the Go panic format
+func allGoroutines() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineDump {
+			return string(buf[:n])
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// loggerPackage is the import path of this package used to recognize its frames in the call stack.
+// It is read from a type so it is still correct when the package is vendored or copied under another path.
+var loggerPackage = reflect.TypeOf(Options{}).PkgPath()
+
+// isLoggerCall checks if the stack frame is a call from the logger or from a package set to be skipped
+func isLoggerCall(o *Options, frame runtime.Frame, skipPackages ...string) bool {
+
+	pkg := packageName(frame.Function)
+
+	if pkg == loggerPackage {
+		// The package's own tests call the logger like any other caller
+		return !strings.HasSuffix(frame.File, "_test.go")
+	}
+
+	for _, p := range o.SkipPackages {
+		if pkg == p {
+			return true
+		}
+	}
+
+	for _, p := range skipPackages {
+		if pkg == p {
+			return true
+		}
+	}
 
+	return false
 }
 
 func cleanFuncName(name string) string {
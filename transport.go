@@ -0,0 +1,374 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	traceParentHeader      = "traceparent"
+	defaultRetryWait       = 100 * time.Millisecond
+	defaultMaxBodySize     = 4096
+	redacted               = "[REDACTED]"
+)
+
+// DefaultRedactKeys are the JSON keys and form fields whose values are replaced in logged bodies
+var DefaultRedactKeys = []string{
+	"password", "passwd", "secret", "token", "access_token", "refresh_token",
+	"client_secret", "api_key", "apikey", "authorization",
+}
+
+// TransportOptions for logging outbound HTTP requests
+type TransportOptions struct {
+
+	// RequestIDHeader is the header the request id from the context is sent in. Defaults to X-Request-ID.
+	RequestIDHeader *string
+
+	// MaxRetries is the number of times a request is retried after a network error or a 502, 503, or 504 response.
+	// Only requests which are safe to repeat and whose body can be sent again are retried.
+	MaxRetries *int
+
+	// RetryWait is the wait before the first retry. It doubles for each retry after. Defaults to 100ms.
+	RetryWait *time.Duration
+
+	// LogBodies logs the request and response bodies at level Debug.
+	// The response body is read up to MaxBodySize before the response is returned.
+	LogBodies *bool
+
+	// MaxBodySize is the number of bytes of each body that is logged. Defaults to 4096.
+	MaxBodySize *int
+
+	// RedactKeys are the JSON keys and form fields whose values are replaced in logged bodies.
+	// Defaults to DefaultRedactKeys.
+	RedactKeys []string
+}
+
+func NewTransportOptions() *TransportOptions {
+	return new(TransportOptions)
+}
+
+func (o *TransportOptions) SetRequestIDHeader(header string) *TransportOptions {
+	o.RequestIDHeader = &header
+	return o
+}
+
+func (o *TransportOptions) GetRequestIDHeader() string {
+	if o.RequestIDHeader == nil {
+		return defaultRequestIDHeader
+	}
+	return *o.RequestIDHeader
+}
+
+func (o *TransportOptions) SetMaxRetries(i int) *TransportOptions {
+	o.MaxRetries = &i
+	return o
+}
+
+func (o *TransportOptions) GetMaxRetries() int {
+	if o.MaxRetries == nil {
+		return 0
+	}
+	return *o.MaxRetries
+}
+
+func (o *TransportOptions) SetRetryWait(d time.Duration) *TransportOptions {
+	o.RetryWait = &d
+	return o
+}
+
+func (o *TransportOptions) GetRetryWait() time.Duration {
+	if o.RetryWait == nil {
+		return defaultRetryWait
+	}
+	return *o.RetryWait
+}
+
+func (o *TransportOptions) SetLogBodies(b bool) *TransportOptions {
+	o.LogBodies = &b
+	return o
+}
+
+func (o *TransportOptions) GetLogBodies() bool {
+	if o.LogBodies == nil {
+		return false
+	}
+	return *o.LogBodies
+}
+
+func (o *TransportOptions) SetMaxBodySize(i int) *TransportOptions {
+	o.MaxBodySize = &i
+	return o
+}
+
+func (o *TransportOptions) GetMaxBodySize() int {
+	if o.MaxBodySize == nil {
+		return defaultMaxBodySize
+	}
+	return *o.MaxBodySize
+}
+
+func (o *TransportOptions) SetRedactKeys(keys ...string) *TransportOptions {
+	o.RedactKeys = keys
+	return o
+}
+
+func (o *TransportOptions) GetRedactKeys() []string {
+	if o.RedactKeys == nil {
+		return DefaultRedactKeys
+	}
+	return o.RedactKeys
+}
+
+// transport logs each request sent through the base round tripper
+type transport struct {
+	base    http.RoundTripper
+	options TransportOptions
+	redact  *bodyRedactor
+}
+
+// Transport wraps an http.RoundTripper to log outbound requests with default options.
+// If base is nil http.DefaultTransport is used. For more options use TransportWithOptions.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return TransportWithOptions(base, NewTransportOptions())
+}
+
+// TransportWithOptions wraps an http.RoundTripper to log the method, host, path, status, duration,
+// and retries of each outbound request. The request id and traceparent set on the request context
+// with WithRequestID and WithTraceParent are sent as headers unless the request already has them.
+func TransportWithOptions(base http.RoundTripper, o *TransportOptions) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{
+		base:    base,
+		options: *o,
+		redact:  newBodyRedactor(o.GetRedactKeys()),
+	}
+}
+
+// RoundTrip sends the request through the base round tripper then logs the outcome
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	start := time.Now()
+	ctx := req.Context()
+
+	req = t.propagate(req)
+
+	logBodies := t.options.GetLogBodies() && logrus.IsLevelEnabled(logrus.DebugLevel)
+
+	var reqBody string
+	if logBodies {
+		reqBody = t.requestBody(req)
+	}
+
+	var (
+		resp    *http.Response
+		err     error
+		retries int
+		wait    = t.options.GetRetryWait()
+	)
+	for {
+		resp, err = t.base.RoundTrip(req)
+
+		if retries >= t.options.GetMaxRetries() || !retryable(req, resp, err) || !sleep(ctx, wait) {
+			break
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				resp = nil
+				break
+			}
+		}
+
+		retries++
+		wait *= 2
+	}
+
+	fields := Fields{
+		"method":      req.Method,
+		"host":        req.URL.Host,
+		"path":        req.URL.Path,
+		"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+		"retries":     retries,
+	}
+	if id := RequestID(ctx); id != "" {
+		fields["request_id"] = id
+	}
+
+	// Extend the logger in the context so calls made while handling a request keep its fields
+	entry := FromContext(ctx).AddSkipPackages("net/http")
+
+	switch {
+	case err != nil:
+		fields["error"] = err
+		entry.WithFields(fields).Error("outbound http request failed")
+	case resp.StatusCode >= http.StatusInternalServerError:
+		fields["status"] = resp.StatusCode
+		entry.WithFields(fields).Warn("outbound http request")
+	default:
+		fields["status"] = resp.StatusCode
+		entry.WithFields(fields).Info("outbound http request")
+	}
+
+	if logBodies {
+		bodies := Fields{"method": req.Method, "host": req.URL.Host, "path": req.URL.Path}
+		if reqBody != "" {
+			bodies["request_body"] = reqBody
+		}
+		if resp != nil {
+			if respBody := t.responseBody(resp); respBody != "" {
+				bodies["response_body"] = respBody
+			}
+		}
+		entry.WithFields(bodies).Debug("outbound http request bodies")
+	}
+
+	return resp, err
+}
+
+// propagate copies the request and adds the request id and traceparent headers from its context
+func (t *transport) propagate(req *http.Request) *http.Request {
+
+	ctx := req.Context()
+	id, tp := RequestID(ctx), TraceParent(ctx)
+
+	// A round tripper must not modify the request it is given
+	req = req.Clone(ctx)
+
+	if id != "" && req.Header.Get(t.options.GetRequestIDHeader()) == "" {
+		req.Header.Set(t.options.GetRequestIDHeader(), id)
+	}
+	if tp != "" && req.Header.Get(traceParentHeader) == "" {
+		req.Header.Set(traceParentHeader, tp)
+	}
+
+	return req
+}
+
+// requestBody gets the start of the request body for logging without consuming it
+func (t *transport) requestBody(req *http.Request) string {
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return ""
+	}
+
+	// Read a copy of the body when it can be replayed so retries still work
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return ""
+		}
+		defer body.Close()
+		b, _ := io.ReadAll(io.LimitReader(body, int64(t.options.GetMaxBodySize())))
+		return t.redact.redact(b)
+	}
+
+	var b []byte
+	b, req.Body = peekBody(req.Body, t.options.GetMaxBodySize())
+	return t.redact.redact(b)
+}
+
+// responseBody gets the start of the response body for logging and puts it back for the caller
+func (t *transport) responseBody(resp *http.Response) string {
+	var b []byte
+	b, resp.Body = peekBody(resp.Body, t.options.GetMaxBodySize())
+	return t.redact.redact(b)
+}
+
+// peekBody reads up to n bytes from body and returns them with a body that still reads from the start
+func peekBody(body io.ReadCloser, n int) ([]byte, io.ReadCloser) {
+
+	b, _ := io.ReadAll(io.LimitReader(body, int64(n)))
+
+	return b, struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), body), body}
+}
+
+// retryable checks if the request can be sent again after a network error or a gateway error
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// sleep waits for d and returns false if the context is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// bodyRedactor replaces the values of sensitive keys in JSON and form encoded bodies.
+// It works on text rather than parsing so bodies cut off at the size limit are still redacted.
+type bodyRedactor struct {
+	json *regexp.Regexp
+	form *regexp.Regexp
+}
+
+func newBodyRedactor(keys []string) *bodyRedactor {
+
+	if len(keys) == 0 {
+		return &bodyRedactor{}
+	}
+
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = regexp.QuoteMeta(k)
+	}
+	names := strings.Join(quoted, "|")
+
+	return &bodyRedactor{
+		json: regexp.MustCompile(fmt.Sprintf(`("(?i:%s)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`, names)),
+		form: regexp.MustCompile(fmt.Sprintf(`((?:^|&)(?i:%s)=)[^&]*`, names)),
+	}
+}
+
+func (r *bodyRedactor) redact(b []byte) string {
+	if r.json == nil {
+		return string(b)
+	}
+	b = r.json.ReplaceAll(b, []byte(`${1}"`+redacted+`"`))
+	b = r.form.ReplaceAll(b, []byte(`${1}`+redacted))
+	return string(b)
+}
@@ -0,0 +1,189 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Transport(t *testing.T) {
+
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithTraceParent(ctx, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	entries := logEntries(t, NewOptions().SetIncludeFunc(true), func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/users?page=2", nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	assert.Equal(t, "req-1", headers.Get("X-Request-ID"))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", headers.Get("traceparent"))
+
+	if assert.Len(t, entries, 1) {
+		entry := entries[0]
+		assert.Equal(t, "GET", entry["method"])
+		assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), entry["host"])
+		assert.Equal(t, "/v1/users", entry["path"])
+		assert.Equal(t, float64(http.StatusTeapot), entry["status"])
+		assert.Equal(t, "req-1", entry["request_id"])
+		assert.Equal(t, float64(0), entry["retries"])
+		assert.Contains(t, entry, "duration_ms")
+
+		// The caller is the code using the client rather than net/http
+		assert.True(t, strings.HasPrefix(entry["func"].(string), "logger.Test_Transport"), entry["func"])
+	}
+}
+
+func Test_TransportContext(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+
+	// The logger of the request being handled is extended so its fields are kept
+	ctx := NewContext(context.Background(), WithFields(Fields{"user_id": "u-1"}))
+
+	entries := logEntries(t, NewOptions(), func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "u-1", entries[0]["user_id"])
+		assert.Equal(t, float64(http.StatusOK), entries[0]["status"])
+	}
+}
+
+func Test_TransportRetries(t *testing.T) {
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		failures   int
+		expStatus  int
+		expRetries int
+		expLevel   string
+	}{
+		{
+			name:       "recovers",
+			method:     http.MethodGet,
+			failures:   2,
+			expStatus:  http.StatusOK,
+			expRetries: 2,
+			expLevel:   "info",
+		},
+		{
+			name:       "gives up",
+			method:     http.MethodGet,
+			failures:   5,
+			expStatus:  http.StatusServiceUnavailable,
+			expRetries: 3,
+			expLevel:   "warning",
+		},
+		{
+			name:       "not idempotent",
+			method:     http.MethodPost,
+			failures:   1,
+			expStatus:  http.StatusServiceUnavailable,
+			expRetries: 0,
+			expLevel:   "warning",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			var calls int
+			var bodies []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(b))
+				calls++
+				if calls <= tc.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			o := NewTransportOptions().SetMaxRetries(3).SetRetryWait(time.Millisecond)
+			client := &http.Client{Transport: TransportWithOptions(nil, o)}
+
+			entries := logEntries(t, NewOptions(), func() {
+				req, _ := http.NewRequest(tc.method, srv.URL, strings.NewReader("body"))
+				resp, err := client.Do(req)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expStatus, resp.StatusCode)
+				resp.Body.Close()
+			})
+
+			// The body is sent again with every retry
+			for _, b := range bodies {
+				assert.Equal(t, "body", b)
+			}
+
+			if assert.Len(t, entries, 1) {
+				assert.Equal(t, float64(tc.expRetries), entries[0]["retries"])
+				assert.Equal(t, tc.expLevel, entries[0]["level"])
+			}
+		})
+	}
+}
+
+func Test_TransportBodies(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"token": "abc123", "expires": 3600, "user": {"name": "me"}}`))
+	}))
+	defer srv.Close()
+
+	o := NewTransportOptions().SetLogBodies(true).SetMaxBodySize(40)
+	client := &http.Client{Transport: TransportWithOptions(nil, o)}
+
+	var respBody []byte
+	entries := logEntries(t, NewOptions().SetLevel("debug"), func() {
+		resp, err := client.Post(srv.URL, "application/json", strings.NewReader(`{"user": "me", "password": "hunter2"}`))
+		assert.NoError(t, err)
+		respBody, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	})
+
+	// The caller still reads the whole response
+	assert.Equal(t, `{"token": "abc123", "expires": 3600, "user": {"name": "me"}}`, string(respBody))
+
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "debug", entries[1]["level"])
+		assert.Equal(t, `{"user": "me", "password": "[REDACTED]"}`, entries[1]["request_body"])
+		assert.Equal(t, `{"token": "[REDACTED]", "expires": 3600, "us`, entries[1]["response_body"])
+	}
+}
+
+func Test_bodyRedactor(t *testing.T) {
+
+	r := newBodyRedactor(DefaultRedactKeys)
+
+	assert.Equal(t, `{"Password":"[REDACTED]","name":"me"}`, r.redact([]byte(`{"Password":"a \"quoted\" secret","name":"me"}`)))
+	assert.Equal(t, `{"api_key": "[REDACTED]"}`, r.redact([]byte(`{"api_key": 12345}`)))
+	assert.Equal(t, `{"token": "[REDACTED]"`, r.redact([]byte(`{"token": "cut off at the li`)))
+	assert.Equal(t, `user=me&password=[REDACTED]&next=/`, r.redact([]byte(`user=me&password=hunter2&next=/`)))
+}